@@ -2,10 +2,22 @@ package bosh
 
 import (
 	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/starkandwayne/signalfire/log"
 )
 
+const (
+	//authRetryInterval is how long to wait before trying to renew authentication
+	// again after failing to, and how often to renew tokens of unknown lifetime
+	authRetryInterval = 30 * time.Second
+	//minAuthRenewInterval bounds how often tokens with very short lifetimes are
+	// renewed
+	minAuthRenewInterval = 5 * time.Second
+)
+
 func (b *Client) authHeader() string {
 	var ret string
 	b.authLock.RLock()
@@ -23,9 +35,69 @@ func (b *Client) login() error {
 	return err
 }
 
+func (b *Client) renewAuth() error {
+	b.authLock.Lock()
+	err := b.auth.Renew()
+	b.authLock.Unlock()
+	return err
+}
+
+func (b *Client) authLifetime() time.Duration {
+	b.authLock.RLock()
+	ret := b.auth.Lifetime()
+	b.authLock.RUnlock()
+	return ret
+}
+
+//keepAuthRenewed renews authentication before it expires, until the client is
+// closed
+func (b *Client) keepAuthRenewed() {
+	failed := false
+	for {
+		wait := renewalWait(b.authLifetime())
+		if failed {
+			wait = authRetryInterval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-b.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		b.logger.Debug("Renewing BOSH authentication")
+		err := b.renewAuth()
+		failed = err != nil
+		if failed {
+			b.logger.Error("Error when renewing authentication: %s", err)
+		}
+	}
+}
+
+//renewalWait returns how long to wait before renewing a token with the given
+// lifetime, leaving a fifth of its lifetime to spare
+func renewalWait(lifetime time.Duration) time.Duration {
+	if lifetime <= 0 {
+		return authRetryInterval
+	}
+
+	ret := lifetime * 4 / 5
+	if ret < minAuthRenewInterval {
+		ret = minAuthRenewInterval
+	}
+	return ret
+}
+
 type boshAuthorizer interface {
 	Login() error
+	//Renew extends the authorization, logging in again if it cannot be extended
+	Renew() error
 	Header() string
+	//Lifetime is how long the authorization lasts from when it was last
+	// renewed, or zero if that is unknown
+	Lifetime() time.Duration
 }
 
 type basicAuth struct {
@@ -35,28 +107,91 @@ type basicAuth struct {
 
 func (b *basicAuth) Login() error { return nil }
 
+func (b *basicAuth) Renew() error { return nil }
+
 func (b *basicAuth) Header() string {
 	return "Basic " + base64.StdEncoding.EncodeToString([]byte(b.Username+":"+b.Password))
 }
 
+func (b *basicAuth) Lifetime() time.Duration { return 0 }
+
 type uaaAuth struct {
-	Client      *UAA
-	Logger      *log.Logger
-	accessToken string
-	Username    string
-	Password    string
+	Client       *UAA
+	Logger       *log.Logger
+	accessToken  string
+	refreshToken string
+	lifetime     time.Duration
+	ClientID     string
+	ClientSecret string
+	//Username is empty if the client itself is logged into
+	Username string
+	Password string
 }
 
 func (u *uaaAuth) Login() error {
-	resp, err := u.Client.ClientCredentials(u.Username, u.Password)
+	var resp *UAAAuthResponse
+	var err error
+	if u.Username != "" {
+		resp, err = u.Client.Password(u.ClientID, u.ClientSecret, u.Username, u.Password)
+	} else {
+		resp, err = u.Client.ClientCredentials(u.ClientID, u.ClientSecret)
+	}
 	if err != nil {
+		return u.explain(err)
+	}
+
+	u.setTokens(resp)
+	return nil
+}
+
+//explain describes which credentials were at fault for UAA errors that are
+// caused by bad configuration
+func (u *uaaAuth) explain(err error) error {
+	uaaErr, isUAAErr := err.(*UAAError)
+	if !isUAAErr {
 		return err
 	}
 
-	u.accessToken = resp.AccessToken
+	switch {
+	case uaaErr.Code == "invalid_grant" && u.Username != "":
+		return fmt.Errorf("UAA rejected the username or password of user `%s': %s", u.Username, err)
+	case uaaErr.Code == "invalid_client" || uaaErr.Code == "unauthorized_client" ||
+		(uaaErr.StatusCode == http.StatusUnauthorized && u.Username == ""):
+		return fmt.Errorf("UAA rejected client `%s': %s", u.ClientID, err)
+	}
+
+	return err
+}
+
+func (u *uaaAuth) Renew() error {
+	if u.refreshToken == "" {
+		return u.Login()
+	}
+
+	resp, err := u.Client.RefreshToken(u.ClientID, u.ClientSecret, u.refreshToken)
+	if err != nil {
+		u.Logger.Debug("Could not use refresh token, so logging in again: %s", err)
+		return u.Login()
+	}
+
+	u.setTokens(resp)
 	return nil
 }
 
+func (u *uaaAuth) setTokens(resp *UAAAuthResponse) {
+	u.accessToken = resp.AccessToken
+	u.lifetime = resp.TTL
+	//UAA may not issue a new refresh token when one is used, in which case the
+	// old one stays valid
+	if resp.RefreshToken != "" {
+		u.refreshToken = resp.RefreshToken
+	}
+}
+
 func (u *uaaAuth) Header() string {
 	return "Bearer " + u.accessToken
 }
+
+func (u *uaaAuth) Lifetime() time.Duration {
+	return u.lifetime
+}
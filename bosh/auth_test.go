@@ -0,0 +1,193 @@
+package bosh
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/starkandwayne/signalfire/config"
+	"github.com/starkandwayne/signalfire/log"
+)
+
+func TestRenewalWait(t *testing.T) {
+	tests := []struct {
+		lifetime time.Duration
+		wait     time.Duration
+	}{
+		{0, authRetryInterval},
+		{-time.Second, authRetryInterval},
+		{time.Hour, 48 * time.Minute},
+		{10 * time.Second, 8 * time.Second},
+		{5 * time.Second, minAuthRenewInterval},
+		{time.Second, minAuthRenewInterval},
+	}
+
+	for _, test := range tests {
+		if got := renewalWait(test.lifetime); got != test.wait {
+			t.Errorf("renewalWait(%s) = %s, want %s", test.lifetime, got, test.wait)
+		}
+	}
+}
+
+func TestUAARenewUsesRefreshToken(t *testing.T) {
+	tests := []struct {
+		name               string
+		issueRefreshTokens bool
+		rejectRefresh      bool
+		grants             []string
+	}{
+		{"refresh token", true, false, []string{"client_credentials", "refresh_token"}},
+		{"rejected refresh token", true, true, []string{"client_credentials", "refresh_token", "client_credentials"}},
+		{"no refresh token", false, false, []string{"client_credentials", "client_credentials"}},
+	}
+
+	for _, test := range tests {
+		director := newFakeDirector("uaa", false)
+		director.issueRefreshTokens = test.issueRefreshTokens
+		director.rejectRefresh = test.rejectRefresh
+		client := director.connect(t)
+
+		err := client.renewAuth()
+		if err != nil {
+			t.Errorf("%s: Could not renew: %s", test.name, err)
+		}
+		if header := client.authHeader(); header != "Bearer token-2" {
+			t.Errorf("%s: Expected the renewed token to be used, got `%s'", test.name, header)
+		}
+		if lifetime := client.authLifetime(); lifetime != time.Hour {
+			t.Errorf("%s: Expected a lifetime of an hour, got %s", test.name, lifetime)
+		}
+		grants, _ := director.requests()
+		if strings.Join(grants, ",") != strings.Join(test.grants, ",") {
+			t.Errorf("%s: Expected grants %v, got %v", test.name, test.grants, grants)
+		}
+
+		client.Close()
+		director.close()
+	}
+}
+
+func TestClientLogsInAgainWhenUnauthorized(t *testing.T) {
+	director := newFakeDirector("uaa", false)
+	defer director.close()
+	client := director.connect(t)
+	defer client.Close()
+
+	director.revoke()
+	_, err := client.Deployments()
+	if err != nil {
+		t.Fatalf("Expected the request to be retried after logging in again: %s", err)
+	}
+	grants, deployments := director.requests()
+	if len(grants) != 2 || deployments != 2 {
+		t.Errorf("Expected two logins and two requests, got %v and %d", grants, deployments)
+	}
+}
+
+func TestClientRetriesUnauthorizedOnce(t *testing.T) {
+	tests := []struct {
+		authType    string
+		deployments int
+	}{
+		//The token from logging in again is rejected too
+		{"uaa", 2},
+		//Basic auth credentials would only be rejected again
+		{"basic", 1},
+	}
+
+	for _, test := range tests {
+		director := newFakeDirector(test.authType, false)
+		client := director.connect(t)
+
+		director.lock.Lock()
+		director.rejectAll = true
+		director.lock.Unlock()
+
+		_, err := client.Deployments()
+		if err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("%s: Expected a 401 error, got %v", test.authType, err)
+		}
+		_, deployments := director.requests()
+		if deployments != test.deployments {
+			t.Errorf("%s: Expected %d requests, got %d", test.authType, test.deployments, deployments)
+		}
+
+		client.Close()
+		director.close()
+	}
+}
+
+func TestClientRejectsUnsupportedAuthType(t *testing.T) {
+	director := newFakeDirector("ldap", false)
+	defer director.close()
+	client, err := NewClient(director.config("127.0.0.1"), testLogger())
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	defer client.Close()
+
+	err = client.Connect()
+	if err == nil || err.Error() != "Unsupported auth type `ldap'" {
+		t.Errorf("Expected an unsupported auth type error, got %v", err)
+	}
+}
+
+func TestUAAExplainsRejectedCredentials(t *testing.T) {
+	tests := []struct {
+		username string
+		err      *UAAError
+		want     string
+	}{
+		{"admin", &UAAError{StatusCode: 401, Code: "invalid_grant"},
+			"UAA rejected the username or password of user `admin': Could not authenticate: invalid_grant"},
+		{"admin", &UAAError{StatusCode: 401, Code: "invalid_client"},
+			"UAA rejected client `bosh_cli': Could not authenticate: invalid_client"},
+		{"", &UAAError{StatusCode: 401, Code: "unauthorized"},
+			"UAA rejected client `bosh_cli': Could not authenticate: unauthorized"},
+		{"", &UAAError{StatusCode: 400, Code: "unsupported_grant_type"},
+			"Could not authenticate: unsupported_grant_type"},
+	}
+
+	for _, test := range tests {
+		auth := &uaaAuth{ClientID: "bosh_cli", Username: test.username}
+		if got := auth.explain(test.err).Error(); got != test.want {
+			t.Errorf("explain(%s) = %s, want %s", test.err.Code, got, test.want)
+		}
+	}
+}
+
+func TestUAALeavesCredentialsOutOfDebugLog(t *testing.T) {
+	director := newFakeDirector("uaa", false)
+	director.issueRefreshTokens = true
+	defer director.close()
+
+	out := &bytes.Buffer{}
+	cfg := director.config("127.0.0.1")
+	cfg.Auth = config.BOSHAuth{ClientID: "ops", ClientSecret: "client-shh", Username: "admin", Password: "user-shh"}
+	client, err := NewClient(cfg, &log.Logger{Output: out, Level: log.LevelDebug})
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	defer client.Close()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err)
+	}
+	if err := client.renewAuth(); err != nil {
+		t.Fatalf("Could not renew: %s", err)
+	}
+	//The request is retried with a new token after the old ones are rejected
+	director.revoke()
+	if _, err := client.Deployments(); err != nil {
+		t.Fatalf("Could not get deployments: %s", err)
+	}
+
+	if !strings.Contains(out.String(), "/oauth/token") {
+		t.Errorf("Expected the token requests to be logged, got:\n%s", out)
+	}
+	for _, secret := range []string{"client-shh", "user-shh", "token-1", "refresh-1", "token-2", "token-3"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("Expected `%s' to be left out of the log, got:\n%s", secret, out)
+		}
+	}
+}
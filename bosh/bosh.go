@@ -18,18 +18,27 @@ import (
 
 	"github.com/starkandwayne/signalfire/config"
 	"github.com/starkandwayne/signalfire/log"
+	"gopkg.in/yaml.v2"
 )
 
+//defaultRequestTimeout bounds how long a request to the director or its UAA may
+// take, including reading the response, if no timeout is configured
+const defaultRequestTimeout = 30 * time.Second
+
 type Client struct {
 	client   *http.Client
 	logger   *log.Logger
 	url      string
-	auth     boshAuthorizer
 	name     string
 	uuid     string
-	username string
-	password string
+	auth     boshAuthorizer
+	creds    config.BOSHAuth
 	authLock sync.RWMutex
+	//stop is closed when the client is closed
+	stop      chan struct{}
+	closeOnce sync.Once
+	//renewOnce keeps connecting again from renewing authentication twice over
+	renewOnce sync.Once
 }
 
 func NewClient(config config.BOSH, logger *log.Logger) (*Client, error) {
@@ -44,9 +53,21 @@ func NewClient(config config.BOSH, logger *log.Logger) (*Client, error) {
 		return nil, err
 	}
 
+	proxy, err := proxyFunc(config.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := defaultRequestTimeout
+	if config.RequestTimeout > 0 {
+		timeout = time.Duration(config.RequestTimeout) * time.Second
+	}
+
 	return &Client{
 		client: &http.Client{
+			Timeout: timeout,
 			Transport: &http.Transport{
+				Proxy: proxy,
 				TLSClientConfig: &tls.Config{
 					RootCAs:            certPool,
 					InsecureSkipVerify: config.InsecureSkipVerify,
@@ -57,13 +78,39 @@ func NewClient(config config.BOSH, logger *log.Logger) (*Client, error) {
 				}).Dial,
 			},
 		},
-		username: config.Auth.ClientID,
-		password: config.Auth.ClientSecret,
-		logger:   logger,
-		url:      u,
+		creds:  config.Auth,
+		logger: logger,
+		url:    u,
+		stop:   make(chan struct{}),
 	}, nil
 }
 
+//proxyFunc returns the proxy selection function for the given proxy URL, which
+// is used for both director and UAA requests. If no URL is given, the proxy is
+// chosen by the HTTPS_PROXY, HTTP_PROXY, and NO_PROXY environment variables.
+func proxyFunc(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing proxy URL: %s", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("Unsupported proxy scheme `%s': must be one of http, https, or socks5", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("Proxy URL has no host")
+	}
+
+	return http.ProxyURL(u), nil
+}
+
 func canonizeURL(uStr string) (string, error) {
 	var schemeRegex = regexp.MustCompile("^(http|https)://")
 	if !schemeRegex.MatchString(uStr) {
@@ -115,75 +162,153 @@ func (b *Client) Connect() error {
 	b.name = info.Name
 	b.uuid = info.UUID
 
+	var authorizer boshAuthorizer
 	switch info.Auth.Type {
 	case "basic":
-		b.auth = &basicAuth{
-			Username: b.username,
-			Password: b.password}
+		authorizer = &basicAuth{
+			Username: b.creds.ClientID,
+			Password: b.creds.ClientSecret}
+		//Directors with basic auth have users rather than clients
+		if b.creds.Username != "" {
+			authorizer = &basicAuth{
+				Username: b.creds.Username,
+				Password: b.creds.Password}
+		}
 	case "uaa":
-		b.auth = &uaaAuth{
+		authorizer = &uaaAuth{
 			Client: &UAA{
 				URL:    info.Auth.Options.URL,
 				Client: b.client,
 				Logger: b.logger,
 			},
-			Logger:   b.logger,
-			Username: b.username,
-			Password: b.password,
+			Logger:       b.logger,
+			ClientID:     b.creds.ClientID,
+			ClientSecret: b.creds.ClientSecret,
+			Username:     b.creds.Username,
+			Password:     b.creds.Password,
 		}
+	default:
+		return fmt.Errorf("Unsupported auth type `%s'", info.Auth.Type)
 	}
 
-	err = b.login()
+	b.authLock.Lock()
+	b.auth = authorizer
+	err = b.auth.Login()
+	b.authLock.Unlock()
 	if err != nil {
 		return fmt.Errorf("Error when logging in for the first time: %s", err)
 	}
 
-	go func() {
-		for range time.Tick(30 * time.Second) {
-			b.logger.Debug("Triggering BOSH authentication")
-			err := b.login()
+	//Basic auth credentials never expire
+	if info.Auth.Type == "uaa" {
+		b.renewOnce.Do(func() { go b.keepAuthRenewed() })
+	}
+
+	return nil
+}
+
+//Close stops the client from renewing its authentication
+func (b *Client) Close() {
+	b.closeOnce.Do(func() { close(b.stop) })
+}
+
+func (b *Client) do(req *http.Request, output interface{}) error {
+	bodyBytes, err := b.doRaw(req)
+	if err != nil {
+		return err
+	}
+
+	if output != nil {
+		err := json.Unmarshal(bodyBytes, output)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//doRaw performs the request and returns the response body without decoding it.
+// If the director rejects the authorization, it logs in again and retries the
+// request once.
+func (b *Client) doRaw(req *http.Request) ([]byte, error) {
+	resp, body, err := b.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	b.authLock.RLock()
+	_, isUAA := b.auth.(*uaaAuth)
+	b.authLock.RUnlock()
+	//Basic auth credentials would only be rejected again
+	if isUAA && resp.StatusCode == http.StatusUnauthorized {
+		b.logger.Info("BOSH with name `%s' rejected the authorization, so logging in again", b.name)
+		err = b.login()
+		if err != nil {
+			return nil, fmt.Errorf("%s, and could not log in again: %s", resp.Status, err)
+		}
+
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
 			if err != nil {
-				b.logger.Error("Error when logging in: %s", err)
+				return nil, err
 			}
 		}
-	}()
+		resp, body, err = b.send(req)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return nil
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf(resp.Status)
+	}
+
+	return body, nil
 }
 
-func (b *Client) do(req *http.Request, output interface{}) error {
+//send performs the request with the current authorization, and reads the whole
+// response body
+func (b *Client) send(req *http.Request) (*http.Response, []byte, error) {
+	//A retried request still has the authorization it was first sent with, which
+	// must not be logged
+	req.Header.Del("Authorization")
 	dump, err := httputil.DumpRequestOut(req, true)
 	if err == nil {
 		b.logger.Debug("%s", string(dump))
 	}
 
-	req.Header.Add("Authorization", b.authHeader())
+	req.Header.Set("Authorization", b.authHeader())
 
 	resp, err := b.client.Do(req)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	dump, err = httputil.DumpResponse(resp, true)
+	defer resp.Body.Close()
+	//Manifests and configs hold credentials, so their bodies are left out of the
+	// log
+	dump, err = httputil.DumpResponse(resp, !holdsSecrets(req))
 	if err == nil {
 		b.logger.Debug("%s", string(dump))
 	}
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf(resp.Status)
-	}
 
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	if output != nil {
-		err := json.Unmarshal(bodyBytes, output)
-		if err != nil {
-			return err
-		}
+	return resp, body, nil
+}
+
+//holdsSecrets is true for requests whose responses may hold credentials, such
+// as the manifest of a deployment or director configs
+func holdsSecrets(req *http.Request) bool {
+	if req.URL.Path == "/configs" {
+		return true
 	}
 
-	return nil
+	name := strings.TrimPrefix(req.URL.Path, "/deployments/")
+	return name != req.URL.Path && name != "" && !strings.Contains(name, "/")
 }
 
 func (b *Client) path(path string) string {
@@ -191,8 +316,13 @@ func (b *Client) path(path string) string {
 }
 
 type infoOut struct {
-	Name string `json:"name"`
-	UUID string `json:"uuid"`
+	Name     string `json:"name"`
+	UUID     string `json:"uuid"`
+	Version  string `json:"version"`
+	CPI      string `json:"cpi"`
+	Features map[string]struct {
+		Status bool `json:"status"`
+	} `json:"features"`
 	Auth struct {
 		Type    string `json:"type"`
 		Options struct {
@@ -216,9 +346,43 @@ func (b *Client) info() (*infoOut, error) {
 	return &info, nil
 }
 
+//Info describes the director, as reported by its /info endpoint
+type Info struct {
+	Name string
+	UUID string
+	//Version is given by the director in the form "270.11.1 (00000000)", where
+	// the parenthesized part is the commit of the director build
+	Version string
+	CPI     string
+	//Features maps director feature names, such as config_server or
+	// local_dns, to whether they are enabled
+	Features map[string]bool
+}
+
+func (b *Client) Info() (*Info, error) {
+	info, err := b.info()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &Info{
+		Name:     info.Name,
+		UUID:     info.UUID,
+		Version:  info.Version,
+		CPI:      info.CPI,
+		Features: make(map[string]bool, len(info.Features)),
+	}
+	for name, feature := range info.Features {
+		ret.Features[name] = feature.Status
+	}
+
+	return ret, nil
+}
+
 type Deployment struct {
-	Name     string    `json:"name"`
-	Releases []Release `json:"releases"`
+	Name      string     `json:"name"`
+	Releases  []Release  `json:"releases"`
+	Stemcells []Stemcell `json:"stemcells"`
 }
 
 type Release struct {
@@ -226,6 +390,24 @@ type Release struct {
 	Version string `json:"version"`
 }
 
+type Stemcell struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+var stemcellOSRegex = regexp.MustCompile(`(ubuntu-[a-z]+|centos-[0-9]+|opensuse-[a-z0-9.]+|windows[0-9]+|alpine)`)
+
+//OS returns the operating system of the stemcell, parsed from stemcell names
+// such as bosh-aws-xen-hvm-ubuntu-xenial-go_agent. If no known operating
+// system is found in the name, the whole name is returned.
+func (s Stemcell) OS() string {
+	if os := stemcellOSRegex.FindString(s.Name); os != "" {
+		return os
+	}
+
+	return s.Name
+}
+
 func (b *Client) Deployments() ([]Deployment, error) {
 	req, err := http.NewRequest("GET", b.path("/deployments"), nil)
 	if err != nil {
@@ -241,5 +423,288 @@ func (b *Client) Deployments() ([]Deployment, error) {
 	return ret, nil
 }
 
+//Manifest holds the parts of a deployment manifest that SignalFire uses
+type Manifest struct {
+	//Raw is the manifest YAML as returned by the director
+	Raw  string
+	Tags map[string]string
+	//Releases are the names of the releases listed in the manifest itself
+	Releases []string
+}
+
+func (b *Client) Manifest(deployment string) (*Manifest, error) {
+	req, err := http.NewRequest("GET", b.path("/deployments/"+url.PathEscape(deployment)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	out := struct {
+		Manifest string `json:"manifest"`
+	}{}
+	err = b.do(req, &out)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting manifest for deployment `%s': %s", deployment, err)
+	}
+
+	parsed := struct {
+		Tags     map[string]string `yaml:"tags"`
+		Releases []Release         `yaml:"releases"`
+	}{}
+	err = yaml.Unmarshal([]byte(out.Manifest), &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing manifest for deployment `%s': %s", deployment, err)
+	}
+
+	ret := &Manifest{Raw: out.Manifest, Tags: parsed.Tags, Releases: []string{}}
+	for _, release := range parsed.Releases {
+		ret.Releases = append(ret.Releases, release.Name)
+	}
+
+	return ret, nil
+}
+
 func (b *Client) Name() string { return b.name }
 func (b *Client) UUID() string { return b.uuid }
+func (b *Client) URL() string  { return b.url }
+
+//Task is a BOSH director task, as returned by /tasks/{id}
+type Task struct {
+	ID          int    `json:"id"`
+	State       string `json:"state"`
+	Description string `json:"description"`
+	Result      string `json:"result"`
+	User        string `json:"user"`
+	Deployment  string `json:"deployment"`
+	Timestamp   int64  `json:"timestamp"`
+	StartedAt   int64  `json:"started_at"`
+}
+
+//Done returns true if the task will make no further progress
+func (t Task) Done() bool {
+	switch t.State {
+	case "done", "error", "cancelled", "timeout":
+		return true
+	}
+
+	return false
+}
+
+//Failed returns true if the task finished without succeeding, because it
+// errored, timed out, or was cancelled
+func (t Task) Failed() bool {
+	return t.Done() && t.State != "done"
+}
+
+func (b *Client) Task(id int) (*Task, error) {
+	req, err := http.NewRequest("GET", b.path(fmt.Sprintf("/tasks/%d", id)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := Task{}
+	err = b.do(req, &ret)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting task %d: %s", id, err)
+	}
+
+	return &ret, nil
+}
+
+//taskPollInterval is how long to wait between checks of a running task
+const taskPollInterval = 2 * time.Second
+
+//waitForTask polls the task until it is done, and returns an error if it did
+// not succeed or did not finish within the timeout
+func (b *Client) waitForTask(id int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		task, err := b.Task(id)
+		if err != nil {
+			return err
+		}
+
+		if task.Done() {
+			if task.State != "done" {
+				return fmt.Errorf("Task %d finished in state `%s': %s", id, task.State, task.Result)
+			}
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out waiting for task %d", id)
+		}
+		time.Sleep(taskPollInterval)
+	}
+}
+
+//Instance describes one instance of a deployment, as reported by the director
+type Instance struct {
+	InstanceGroup string   `json:"job_name"`
+	Index         *int     `json:"index"`
+	ID            string   `json:"id"`
+	AZ            string   `json:"az"`
+	ProcessState  string   `json:"process_state"`
+	VMType        string   `json:"vm_type"`
+	VMCID         string   `json:"vm_cid"`
+	IPs           []string `json:"ips"`
+}
+
+//instancesTaskTimeout bounds how long the director may take to gather the
+// state of a deployment's instances
+const instancesTaskTimeout = 5 * time.Minute
+
+//Instances returns the full state of every instance of the deployment. The
+// director gathers this in a task, so this may take some time.
+func (b *Client) Instances(deployment string) ([]Instance, error) {
+	req, err := http.NewRequest("GET", b.path("/deployments/"+url.PathEscape(deployment)+"/instances?format=full"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	//The director redirects to the task which gathers the instance states
+	task := Task{}
+	err = b.do(req, &task)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting instances of deployment `%s': %s", deployment, err)
+	}
+
+	err = b.waitForTask(task.ID, instancesTaskTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting instances of deployment `%s': %s", deployment, err)
+	}
+
+	req, err = http.NewRequest("GET", b.path(fmt.Sprintf("/tasks/%d/output?type=result", task.ID)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := b.doRaw(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting output of task %d: %s", task.ID, err)
+	}
+
+	//The result is one JSON object per line
+	ret := []Instance{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		instance := Instance{}
+		err = json.Unmarshal([]byte(line), &instance)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing output of task %d: %s", task.ID, err)
+		}
+		ret = append(ret, instance)
+	}
+
+	return ret, nil
+}
+
+//Tasks returns up to limit of the most recent tasks of the deployment, newest
+// first. If the deployment is empty, the tasks of every deployment are returned.
+func (b *Client) Tasks(deployment string, limit int) ([]Task, error) {
+	q := url.Values{}
+	if deployment != "" {
+		q.Set("deployment", deployment)
+	}
+	q.Set("limit", fmt.Sprintf("%d", limit))
+	q.Set("verbose", "1")
+	req, err := http.NewRequest("GET", b.path("/tasks?"+q.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []Task{}
+	err = b.do(req, &ret)
+	if err != nil {
+		if deployment == "" {
+			return nil, fmt.Errorf("Error getting tasks: %s", err)
+		}
+		return nil, fmt.Errorf("Error getting tasks of deployment `%s': %s", deployment, err)
+	}
+
+	return ret, nil
+}
+
+//Types of config that a director holds
+const (
+	ConfigTypeCloud   = "cloud"
+	ConfigTypeRuntime = "runtime"
+	ConfigTypeCPI     = "cpi"
+)
+
+//configTimeFormat is the format of the timestamps of configs
+const configTimeFormat = "2006-01-02 15:04:05 MST"
+
+//Config is a version of a named config of some type, as returned by /configs
+type Config struct {
+	ID      string
+	Name    string
+	Type    string
+	Content string
+	//Current is true if this is the version of the config currently in use
+	Current bool
+	//CreatedAt is zero if the director gave a timestamp that could not be parsed
+	CreatedAt time.Time
+}
+
+//Configs returns up to limit of the most recent versions of every config of the
+// given type, newest first
+func (b *Client) Configs(configType string, limit int) ([]Config, error) {
+	q := url.Values{}
+	q.Set("type", configType)
+	q.Set("latest", "false")
+	q.Set("limit", fmt.Sprintf("%d", limit))
+	req, err := http.NewRequest("GET", b.path("/configs?"+q.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []struct {
+		ID        string `json:"id"`
+		Name      string `json:"name"`
+		Type      string `json:"type"`
+		Content   string `json:"content"`
+		Current   bool   `json:"current"`
+		CreatedAt string `json:"created_at"`
+	}{}
+	err = b.do(req, &out)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting %s configs: %s", configType, err)
+	}
+
+	ret := make([]Config, 0, len(out))
+	for _, config := range out {
+		createdAt, _ := time.Parse(configTimeFormat, config.CreatedAt)
+		ret = append(ret, Config{
+			ID:        config.ID,
+			Name:      config.Name,
+			Type:      config.Type,
+			Content:   config.Content,
+			Current:   config.Current,
+			CreatedAt: createdAt.UTC(),
+		})
+	}
+
+	return ret, nil
+}
+
+//RuntimeConfigReleases returns the names of the releases that a runtime config
+// adds to deployments
+func RuntimeConfigReleases(content string) ([]string, error) {
+	parsed := struct {
+		Releases []Release `yaml:"releases"`
+	}{}
+	err := yaml.Unmarshal([]byte(content), &parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0, len(parsed.Releases))
+	for _, release := range parsed.Releases {
+		ret = append(ret, release.Name)
+	}
+
+	return ret, nil
+}
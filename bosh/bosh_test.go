@@ -0,0 +1,80 @@
+package bosh
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/starkandwayne/signalfire/config"
+	"github.com/starkandwayne/signalfire/log"
+)
+
+func TestClientTimesOutHangingDirector(t *testing.T) {
+	//The director accepts connections, but never responds
+	director := newFakeDirector("uaa", false)
+	director.hang = true
+	defer director.close()
+
+	cfg := director.config("127.0.0.1")
+	cfg.RequestTimeout = 1
+	client, err := NewClient(cfg, testLogger())
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+
+	connected := make(chan error, 1)
+	go func() { connected <- client.Connect() }()
+	select {
+	case err := <-connected:
+		if err == nil {
+			t.Errorf("Expected connecting to a hanging director to fail")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Expected connecting to a hanging director to time out")
+	}
+}
+
+func TestClientDefaultRequestTimeout(t *testing.T) {
+	client, err := NewClient(config.BOSH{URL: "10.0.0.6"}, testLogger())
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	if client.client.Timeout != defaultRequestTimeout {
+		t.Errorf("Expected a timeout of %s, got %s", defaultRequestTimeout, client.client.Timeout)
+	}
+}
+
+func TestClientLeavesSecretsOutOfDebugLog(t *testing.T) {
+	director := newFakeDirector("uaa", false)
+	director.bodies["/deployments/cf"] = `{"manifest":"properties: {admin_password: hunter2}"}`
+	director.bodies["/configs"] = `[{"id":"1","name":"aws","type":"cpi","content":"cpis: [{properties: {api_key: hunter3}}]"}]`
+	defer director.close()
+
+	out := &bytes.Buffer{}
+	client, err := NewClient(director.config("127.0.0.1"), &log.Logger{Output: out, Level: log.LevelDebug})
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	defer client.Close()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err)
+	}
+	if _, err := client.Manifest("cf"); err != nil {
+		t.Fatalf("Could not get manifest: %s", err)
+	}
+	if _, err := client.Configs(ConfigTypeCPI, 1); err != nil {
+		t.Fatalf("Could not get configs: %s", err)
+	}
+
+	for _, path := range []string{"/deployments/cf", "/configs"} {
+		if !strings.Contains(out.String(), path) {
+			t.Errorf("Expected the request for %s to be logged, got:\n%s", path, out)
+		}
+	}
+	for _, secret := range []string{"hunter2", "hunter3"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("Expected `%s' to be left out of the log, got:\n%s", secret, out)
+		}
+	}
+}
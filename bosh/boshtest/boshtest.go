@@ -0,0 +1,648 @@
+//Package boshtest provides a fake BOSH director, along with the UAA that it
+// authenticates through, which runs in-process for tests and demos. The
+// deployments, instances, tasks, and configs of the director can be changed
+// while it runs, and faults can be injected into its responses.
+package boshtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/starkandwayne/signalfire/bosh"
+	"github.com/starkandwayne/signalfire/config"
+	"gopkg.in/yaml.v2"
+)
+
+//Credentials which the UAA of the director accepts. Users may log in through
+// either the client or the client that the BOSH CLI uses.
+const (
+	ClientID     = "signalfire"
+	ClientSecret = "fake-secret"
+	Username     = "admin"
+	Password     = "fake-password"
+)
+
+//configTimeFormat is the format in which directors give config timestamps
+const configTimeFormat = "2006-01-02 15:04:05 MST"
+
+//Deployment is a deployment on the fake director
+type Deployment struct {
+	Name      string
+	Releases  []bosh.Release
+	Stemcells []bosh.Stemcell
+	Tags      map[string]string
+	//Manifest is generated from the rest of the deployment if it is empty
+	Manifest string
+	//Instances are reported by the task that gathers their state
+	Instances []bosh.Instance
+}
+
+//Fault changes the responses to requests for a path
+type Fault struct {
+	//Status is returned instead of the usual response, if it is not zero
+	Status int
+	//Delay is how long to wait before responding. Requests are abandoned if the
+	// client gives up first.
+	Delay time.Duration
+	//Times is how many requests the fault applies to. If it is zero, the fault
+	// applies until it is cleared.
+	Times int
+}
+
+//Director is a fake BOSH director. Create one with NewDirector, and Close it
+// when done.
+type Director struct {
+	name    string
+	uuid    string
+	version string
+	//tokenTTL is the lifetime of the UAA tokens that are issued
+	tokenTTL time.Duration
+
+	server      *httptest.Server
+	deployments []Deployment
+	tasks       map[string][]bosh.Task
+	//instanceTasks are the tasks which gathered the state of instances, by ID.
+	// Like on real directors, they are not listed with the other tasks.
+	instanceTasks map[int]instanceTask
+	configs       []bosh.Config
+	faults        map[string]*Fault
+	tokens        map[string]bool
+	requests      map[string]int
+	lastID        int
+	lock          sync.Mutex
+}
+
+//NewDirector starts a fake director with the given name and UUID, which has no
+// deployments
+func NewDirector(name, uuid string) *Director {
+	ret := &Director{
+		name:          name,
+		uuid:          uuid,
+		version:       "270.11.1 (00000000)",
+		tokenTTL:      time.Hour,
+		tasks:         map[string][]bosh.Task{},
+		instanceTasks: map[int]instanceTask{},
+		faults:        map[string]*Fault{},
+		tokens:        map[string]bool{},
+		requests:      map[string]int{},
+	}
+	ret.server = httptest.NewServer(http.HandlerFunc(ret.serveHTTP))
+	return ret
+}
+
+func (d *Director) Close() {
+	d.server.Close()
+}
+
+func (d *Director) URL() string {
+	return d.server.URL
+}
+
+func (d *Director) UUID() string {
+	return d.uuid
+}
+
+//SetVersion changes the version that the director reports, which is initially
+// "270.11.1 (00000000)"
+func (d *Director) SetVersion(version string) {
+	d.lock.Lock()
+	d.version = version
+	d.lock.Unlock()
+}
+
+//SetTokenTTL changes the lifetime of the UAA tokens issued from now on, which
+// is initially an hour
+func (d *Director) SetTokenTTL(ttl time.Duration) {
+	d.lock.Lock()
+	d.tokenTTL = ttl
+	d.lock.Unlock()
+}
+
+//Config returns the configuration of a target for the director
+func (d *Director) Config() config.BOSH {
+	return config.BOSH{
+		URL:  d.URL(),
+		Auth: config.BOSHAuth{ClientID: ClientID, ClientSecret: ClientSecret},
+	}
+}
+
+//AddDeployment adds the deployment, replacing any with the same name
+func (d *Director) AddDeployment(deployment Deployment) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for i := range d.deployments {
+		if d.deployments[i].Name == deployment.Name {
+			d.deployments[i] = deployment
+			return
+		}
+	}
+
+	d.deployments = append(d.deployments, deployment)
+}
+
+//RemoveDeployment removes the named deployment, along with its tasks
+func (d *Director) RemoveDeployment(name string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for i := range d.deployments {
+		if d.deployments[i].Name == name {
+			d.deployments = append(d.deployments[:i], d.deployments[i+1:]...)
+			break
+		}
+	}
+
+	delete(d.tasks, name)
+}
+
+//SetReleaseVersion changes the version of a release of the named deployment. It
+// returns false if the deployment does not have the release.
+func (d *Director) SetReleaseVersion(deployment, release, version string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for i := range d.deployments {
+		if d.deployments[i].Name != deployment {
+			continue
+		}
+
+		releases := d.deployments[i].Releases
+		for j := range releases {
+			if releases[j].Name == release {
+				releases[j].Version = version
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//SetInstances replaces the instances of the named deployment. It returns false
+// if there is no such deployment.
+func (d *Director) SetInstances(deployment string, instances []bosh.Instance) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for i := range d.deployments {
+		if d.deployments[i].Name == deployment {
+			d.deployments[i].Instances = instances
+			return true
+		}
+	}
+
+	return false
+}
+
+//AddTask records a task of the named deployment as the newest. If the task has
+// no ID, it is given the next one, and if it has no timestamp, it is given the
+// current time.
+func (d *Director) AddTask(deployment string, task bosh.Task) bosh.Task {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if task.ID == 0 {
+		d.lastID++
+		task.ID = d.lastID
+	}
+	if task.Timestamp == 0 {
+		task.Timestamp = time.Now().Unix()
+	}
+	task.Deployment = deployment
+
+	d.tasks[deployment] = append([]bosh.Task{task}, d.tasks[deployment]...)
+	return task
+}
+
+//AddConfig records a new version of a config, which becomes the current one.
+// It is given the next ID and the current time.
+func (d *Director) AddConfig(configType, name, content string) bosh.Config {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for i := range d.configs {
+		if d.configs[i].Type == configType && d.configs[i].Name == name {
+			d.configs[i].Current = false
+		}
+	}
+
+	d.lastID++
+	toAdd := bosh.Config{
+		ID:        strconv.Itoa(d.lastID),
+		Name:      name,
+		Type:      configType,
+		Content:   content,
+		Current:   true,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	d.configs = append(d.configs, toAdd)
+	return toAdd
+}
+
+//InjectFault applies the fault to requests for the given path, such as
+// "/deployments", replacing any fault already injected for it
+func (d *Director) InjectFault(path string, fault Fault) {
+	d.lock.Lock()
+	d.faults[path] = &fault
+	d.lock.Unlock()
+}
+
+func (d *Director) ClearFaults() {
+	d.lock.Lock()
+	d.faults = map[string]*Fault{}
+	d.lock.Unlock()
+}
+
+//RevokeTokens invalidates every token issued so far, so that requests with them
+// are rejected with a 401
+func (d *Director) RevokeTokens() {
+	d.lock.Lock()
+	d.tokens = map[string]bool{}
+	d.lock.Unlock()
+}
+
+//Requests returns how many requests have been made for the given path
+func (d *Director) Requests(path string) int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.requests[path]
+}
+
+func (d *Director) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	d.lock.Lock()
+	d.requests[r.URL.Path]++
+	fault := d.takeFault(r.URL.Path)
+	d.lock.Unlock()
+
+	if fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if fault.Status != 0 {
+		w.WriteHeader(fault.Status)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/info":
+		d.serveInfo(w)
+		return
+	case r.URL.Path == "/oauth/token":
+		d.serveToken(w, r)
+		return
+	}
+
+	if !d.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/deployments":
+		d.serveDeployments(w)
+	case strings.HasPrefix(r.URL.Path, "/deployments/") && strings.HasSuffix(r.URL.Path, "/instances"):
+		d.serveInstances(w, r, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/deployments/"), "/instances"))
+	case strings.HasPrefix(r.URL.Path, "/deployments/"):
+		d.serveDeployment(w, strings.TrimPrefix(r.URL.Path, "/deployments/"))
+	case r.URL.Path == "/tasks":
+		d.serveTasks(w, r)
+	case strings.HasPrefix(r.URL.Path, "/tasks/"):
+		d.serveTask(w, r, strings.TrimPrefix(r.URL.Path, "/tasks/"))
+	case r.URL.Path == "/configs":
+		d.serveConfigs(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+//takeFault returns the fault for the path, counting the request against it. It
+// must be called with the lock held.
+func (d *Director) takeFault(path string) Fault {
+	fault, found := d.faults[path]
+	if !found {
+		return Fault{}
+	}
+
+	if fault.Times > 0 {
+		fault.Times--
+		if fault.Times == 0 {
+			delete(d.faults, path)
+		}
+	}
+
+	return *fault
+}
+
+func (d *Director) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.tokens[token]
+}
+
+func writeJSON(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(obj)
+}
+
+func (d *Director) serveInfo(w http.ResponseWriter) {
+	d.lock.Lock()
+	version := d.version
+	d.lock.Unlock()
+
+	info := map[string]interface{}{
+		"name":    d.name,
+		"uuid":    d.uuid,
+		"version": version,
+		"cpi":     "fake_cpi",
+		"features": map[string]interface{}{
+			"config_server": map[string]bool{"status": true},
+		},
+		"user_authentication": map[string]interface{}{
+			"type":    "uaa",
+			"options": map[string]string{"url": d.URL()},
+		},
+	}
+
+	writeJSON(w, info)
+}
+
+func (d *Director) serveToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	grant := r.PostForm.Get("grant_type")
+	clientID, clientSecret, _ := r.BasicAuth()
+	validClient := clientID == ClientID && clientSecret == ClientSecret
+	if grant != "client_credentials" && clientID == config.DefaultBOSHClientID && clientSecret == "" {
+		validClient = true
+	}
+	if !validClient {
+		writeTokenError(w, http.StatusUnauthorized, "unauthorized", "Bad credentials")
+		return
+	}
+
+	switch grant {
+	case "client_credentials", "refresh_token":
+	case "password":
+		if r.PostForm.Get("username") != Username || r.PostForm.Get("password") != Password {
+			writeTokenError(w, http.StatusUnauthorized, "invalid_grant", "Bad credentials")
+			return
+		}
+	default:
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	d.lock.Lock()
+	d.lastID++
+	token := fmt.Sprintf("token-%d", d.lastID)
+	d.tokens[token] = true
+	ttl := d.tokenTTL
+	d.lock.Unlock()
+
+	writeJSON(w, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "bearer",
+		"expires_in":   int64(ttl / time.Second),
+	})
+}
+
+func writeTokenError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
+
+func (d *Director) serveDeployments(w http.ResponseWriter) {
+	type deploymentOut struct {
+		Name      string          `json:"name"`
+		Releases  []bosh.Release  `json:"releases"`
+		Stemcells []bosh.Stemcell `json:"stemcells"`
+	}
+
+	d.lock.Lock()
+	out := make([]deploymentOut, 0, len(d.deployments))
+	for _, deployment := range d.deployments {
+		out = append(out, deploymentOut{
+			Name:      deployment.Name,
+			Releases:  append([]bosh.Release{}, deployment.Releases...),
+			Stemcells: append([]bosh.Stemcell{}, deployment.Stemcells...),
+		})
+	}
+	d.lock.Unlock()
+
+	writeJSON(w, out)
+}
+
+//findDeployment returns a copy of the named deployment, or nil if there is
+// none. It must be called with the lock held.
+func (d *Director) findDeployment(name string) *Deployment {
+	for i := range d.deployments {
+		if d.deployments[i].Name == name {
+			deployment := d.deployments[i]
+			return &deployment
+		}
+	}
+
+	return nil
+}
+
+func (d *Director) serveDeployment(w http.ResponseWriter, name string) {
+	d.lock.Lock()
+	found := d.findDeployment(name)
+	var manifest string
+	if found != nil {
+		manifest = found.manifest()
+	}
+	d.lock.Unlock()
+
+	if found == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, map[string]string{"manifest": manifest})
+}
+
+func (deployment Deployment) manifest() string {
+	if deployment.Manifest != "" {
+		return deployment.Manifest
+	}
+
+	type release struct {
+		Name    string `yaml:"name"`
+		Version string `yaml:"version"`
+	}
+	type stemcell struct {
+		Alias   string `yaml:"alias"`
+		Name    string `yaml:"name"`
+		Version string `yaml:"version"`
+	}
+	manifest := struct {
+		Name      string            `yaml:"name"`
+		Releases  []release         `yaml:"releases"`
+		Stemcells []stemcell        `yaml:"stemcells"`
+		Tags      map[string]string `yaml:"tags,omitempty"`
+	}{
+		Name:      deployment.Name,
+		Releases:  []release{},
+		Stemcells: []stemcell{},
+		Tags:      deployment.Tags,
+	}
+	for _, r := range deployment.Releases {
+		manifest.Releases = append(manifest.Releases, release{Name: r.Name, Version: r.Version})
+	}
+	for i, s := range deployment.Stemcells {
+		manifest.Stemcells = append(manifest.Stemcells, stemcell{
+			Alias:   fmt.Sprintf("default%d", i),
+			Name:    s.Name,
+			Version: s.Version,
+		})
+	}
+
+	out, _ := yaml.Marshal(&manifest)
+	return string(out)
+}
+
+//instanceTask is a task which gathered the state of instances, along with its
+// result
+type instanceTask struct {
+	task   bosh.Task
+	result string
+}
+
+//serveInstances starts a task which gathers the state of the instances of the
+// named deployment, and redirects to it. The task is done at once.
+func (d *Director) serveInstances(w http.ResponseWriter, r *http.Request, name string) {
+	d.lock.Lock()
+	found := d.findDeployment(name)
+	if found == nil {
+		d.lock.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	//The result is one JSON object per line
+	result := ""
+	for _, instance := range found.Instances {
+		line, _ := json.Marshal(instance)
+		result += string(line) + "\n"
+	}
+	d.lastID++
+	task := bosh.Task{
+		ID:          d.lastID,
+		State:       "done",
+		Description: "retrieve vm-stats",
+		Timestamp:   time.Now().Unix(),
+		Deployment:  name,
+	}
+	d.instanceTasks[task.ID] = instanceTask{task: task, result: result}
+	d.lock.Unlock()
+
+	http.Redirect(w, r, fmt.Sprintf("/tasks/%d", task.ID), http.StatusFound)
+}
+
+//serveTask serves a task, or its result if the path ends in "/output"
+func (d *Director) serveTask(w http.ResponseWriter, r *http.Request, path string) {
+	id, err := strconv.Atoi(strings.TrimSuffix(path, "/output"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	d.lock.Lock()
+	found, isInstanceTask := d.instanceTasks[id]
+	if !isInstanceTask {
+		for _, tasks := range d.tasks {
+			for _, task := range tasks {
+				if task.ID == id {
+					found.task = task
+				}
+			}
+		}
+	}
+	d.lock.Unlock()
+
+	switch {
+	case found.task.ID == 0:
+		w.WriteHeader(http.StatusNotFound)
+	case strings.HasSuffix(path, "/output"):
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, found.result)
+	default:
+		writeJSON(w, found.task)
+	}
+}
+
+func (d *Director) serveTasks(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		limit = 30
+	}
+
+	tasks := []bosh.Task{}
+	d.lock.Lock()
+	if deployment := r.URL.Query().Get("deployment"); deployment != "" {
+		tasks = append(tasks, d.tasks[deployment]...)
+	} else {
+		for _, deploymentTasks := range d.tasks {
+			tasks = append(tasks, deploymentTasks...)
+		}
+	}
+	d.lock.Unlock()
+
+	//Directors list the newest first
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].ID > tasks[j].ID })
+
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
+	writeJSON(w, tasks)
+}
+
+func (d *Director) serveConfigs(w http.ResponseWriter, r *http.Request) {
+	type configOut struct {
+		ID        string `json:"id"`
+		Name      string `json:"name"`
+		Type      string `json:"type"`
+		Content   string `json:"content"`
+		Current   bool   `json:"current"`
+		CreatedAt string `json:"created_at"`
+	}
+
+	q := r.URL.Query()
+	latestOnly := q.Get("latest") != "false"
+	out := []configOut{}
+	d.lock.Lock()
+	for _, c := range d.configs {
+		if (q.Get("type") != "" && c.Type != q.Get("type")) || (latestOnly && !c.Current) {
+			continue
+		}
+
+		out = append(out, configOut{
+			ID:        c.ID,
+			Name:      c.Name,
+			Type:      c.Type,
+			Content:   c.Content,
+			Current:   c.Current,
+			CreatedAt: c.CreatedAt.Format(configTimeFormat),
+		})
+	}
+	d.lock.Unlock()
+
+	//Directors list the newest first
+	sort.SliceStable(out, func(i, j int) bool {
+		a, _ := strconv.Atoi(out[i].ID)
+		b, _ := strconv.Atoi(out[j].ID)
+		return a > b
+	})
+	if limit, err := strconv.Atoi(q.Get("limit")); err == nil && len(out) > limit {
+		out = out[:limit]
+	}
+
+	writeJSON(w, out)
+}
@@ -0,0 +1,175 @@
+package bosh
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/starkandwayne/signalfire/config"
+	"github.com/starkandwayne/signalfire/log"
+)
+
+//fakeDirector is a director for tests of the client, which authenticates
+// through a UAA on a server of its own. The director gives the UAA under the
+// hostname that the director itself was reached by. It only accepts tokens
+// which its UAA issued and which have not been revoked.
+type fakeDirector struct {
+	server *httptest.Server
+	uaa    *httptest.Server
+	//authType is the type of authentication that the director reports
+	authType string
+	//issueRefreshTokens makes the UAA issue a refresh token with each token
+	issueRefreshTokens bool
+	//rejectRefresh makes the UAA reject refresh token grants
+	rejectRefresh bool
+	//rejectAll makes the director reject every token, even newly issued ones
+	rejectAll bool
+	//hang makes the director accept requests, but never respond to them
+	hang bool
+	//bodies are served to authorized requests for paths other than /info and
+	// /deployments
+	bodies map[string]string
+
+	lock        sync.Mutex
+	issued      int
+	valid       map[string]bool
+	grants      []string
+	deployments int
+}
+
+//newFakeDirector starts a director and its UAA, which use TLS if secure is set
+func newFakeDirector(authType string, secure bool) *fakeDirector {
+	newServer := httptest.NewServer
+	if secure {
+		newServer = httptest.NewTLSServer
+	}
+
+	ret := &fakeDirector{authType: authType, bodies: map[string]string{}, valid: map[string]bool{}}
+	ret.server = newServer(http.HandlerFunc(ret.serveDirector))
+	ret.uaa = newServer(http.HandlerFunc(ret.serveUAA))
+	return ret
+}
+
+func (d *fakeDirector) close() {
+	d.server.Close()
+	d.uaa.Close()
+}
+
+func (d *fakeDirector) serveDirector(w http.ResponseWriter, r *http.Request) {
+	d.lock.Lock()
+	if d.hang {
+		d.lock.Unlock()
+		<-r.Context().Done()
+		return
+	}
+	defer d.lock.Unlock()
+
+	authorized := !d.rejectAll && d.valid[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	switch r.URL.Path {
+	case "/info":
+		uaaURL, _ := url.Parse(d.uaa.URL)
+		host, _, _ := net.SplitHostPort(r.Host)
+		uaaURL.Host = net.JoinHostPort(host, uaaURL.Port())
+		fmt.Fprintf(w, `{"name":"test","uuid":"test-uuid","user_authentication":{"type":"%s","options":{"url":"%s"}}}`,
+			d.authType, uaaURL)
+	case "/deployments":
+		d.deployments++
+		if !authorized {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `[{"name":"cf"}]`)
+	default:
+		body, found := d.bodies[r.URL.Path]
+		switch {
+		case !found:
+			w.WriteHeader(http.StatusNotFound)
+		case !authorized:
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			fmt.Fprint(w, body)
+		}
+	}
+}
+
+func (d *fakeDirector) serveUAA(w http.ResponseWriter, r *http.Request) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if r.URL.Path != "/oauth/token" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	r.ParseForm()
+	grant := r.PostForm.Get("grant_type")
+	d.grants = append(d.grants, grant)
+	if grant == "refresh_token" && (d.rejectRefresh || !strings.HasPrefix(r.PostForm.Get("refresh_token"), "refresh-")) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":"invalid_token"}`)
+		return
+	}
+
+	d.issued++
+	token := fmt.Sprintf("token-%d", d.issued)
+	d.valid[token] = true
+	refreshToken := ""
+	if d.issueRefreshTokens {
+		refreshToken = fmt.Sprintf("refresh-%d", d.issued)
+	}
+	fmt.Fprintf(w, `{"access_token":"%s","refresh_token":"%s","expires_in":3600}`, token, refreshToken)
+}
+
+//revoke invalidates every token issued so far
+func (d *fakeDirector) revoke() {
+	d.lock.Lock()
+	d.valid = map[string]bool{}
+	d.lock.Unlock()
+}
+
+func (d *fakeDirector) requests() (grants []string, deployments int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]string{}, d.grants...), d.deployments
+}
+
+//config returns the configuration to reach the director under the given
+// hostname, trusting its certificate if it uses TLS
+func (d *fakeDirector) config(host string) config.BOSH {
+	u, _ := url.Parse(d.server.URL)
+	u.Host = net.JoinHostPort(host, u.Port())
+	ret := config.BOSH{
+		URL:  u.String(),
+		Auth: config.BOSHAuth{ClientID: "signalfire", ClientSecret: "secret"},
+	}
+	if cert := d.server.Certificate(); cert != nil {
+		ret.CACert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	}
+
+	return ret
+}
+
+func (d *fakeDirector) connect(t *testing.T) *Client {
+	client, err := NewClient(d.config("127.0.0.1"), testLogger())
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+
+	err = client.Connect()
+	if err != nil {
+		t.Fatalf("Could not connect: %s", err)
+	}
+
+	return client
+}
+
+func testLogger() *log.Logger {
+	return &log.Logger{Output: ioutil.Discard, Level: log.LevelFatal}
+}
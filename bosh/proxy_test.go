@@ -0,0 +1,290 @@
+package bosh
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/starkandwayne/signalfire/config"
+)
+
+//proxyLog records the hosts which a proxy stand-in was asked to reach
+type proxyLog struct {
+	hosts []string
+	lock  sync.Mutex
+}
+
+func (p *proxyLog) add(host string) {
+	p.lock.Lock()
+	p.hosts = append(p.hosts, host)
+	p.lock.Unlock()
+}
+
+func (p *proxyLog) saw(host string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, h := range p.hosts {
+		if h == host {
+			return true
+		}
+	}
+
+	return false
+}
+
+//testClientThroughProxy connects with the given configuration, and checks that
+// the proxy was asked to reach the director and the UAA under the given
+// hostname
+func testClientThroughProxy(t *testing.T, cfg config.BOSH, seen *proxyLog, host string, director *fakeDirector) {
+	client, err := NewClient(cfg, testLogger())
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	defer client.Close()
+
+	err = client.Connect()
+	if err != nil {
+		t.Fatalf("Could not connect: %s", err)
+	}
+
+	deployments, err := client.Deployments()
+	if err != nil {
+		t.Fatalf("Could not get deployments: %s", err)
+	}
+	if len(deployments) != 1 || deployments[0].Name != "cf" {
+		t.Errorf("Unexpected deployments %+v", deployments)
+	}
+
+	for _, server := range []*httptest.Server{director.server, director.uaa} {
+		_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+		if !seen.saw(net.JoinHostPort(host, port)) {
+			t.Errorf("Expected the proxy to be asked to reach %s:%s", host, port)
+		}
+	}
+}
+
+//newHTTPProxy starts an HTTP proxy stand-in which requires credentials. It
+// forwards requests with absolute URLs and tunnels CONNECT requests, reaching
+// every host at the loopback address.
+func newHTTPProxy(seen *proxyLog) *httptest.Server {
+	transport := &http.Transport{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") == "" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		if r.Method == http.MethodConnect {
+			seen.add(r.Host)
+			tunnel(w, loopback(r.Host))
+			return
+		}
+
+		seen.add(r.URL.Host)
+		r.URL.Host = loopback(r.URL.Host)
+		r.RequestURI = ""
+		r.Header.Del("Proxy-Authorization")
+		resp, err := transport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+}
+
+//loopback returns the given host and port with the host replaced by the
+// loopback address
+func loopback(hostPort string) string {
+	_, port, _ := net.SplitHostPort(hostPort)
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+//tunnel connects the client of the CONNECT request to the target
+func tunnel(w http.ResponseWriter, target string) {
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestHTTPProxy(t *testing.T) {
+	//An HTTPS director is reached through a CONNECT tunnel
+	for _, secure := range []bool{false, true} {
+		director := newFakeDirector("uaa", secure)
+		seen := &proxyLog{}
+		proxy := newHTTPProxy(seen)
+
+		cfg := director.config("127.0.0.1")
+		cfg.Proxy = "http://user:pass@" + proxy.Listener.Addr().String()
+		testClientThroughProxy(t, cfg, seen, "127.0.0.1", director)
+
+		proxy.Close()
+		director.close()
+	}
+}
+
+//TestProxyFromEnvironment runs itself in a new process, as the proxy
+// environment variables are only read once per process
+func TestProxyFromEnvironment(t *testing.T) {
+	if os.Getenv("SIGNALFIRE_TEST_PROXY_ENVIRONMENT") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestProxyFromEnvironment$")
+		cmd.Env = []string{"SIGNALFIRE_TEST_PROXY_ENVIRONMENT=1"}
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Errorf("Test with the proxy environment variables failed: %s\n%s", err, output)
+		}
+		return
+	}
+
+	director := newFakeDirector("uaa", true)
+	defer director.close()
+	seen := &proxyLog{}
+	proxy := newHTTPProxy(seen)
+	defer proxy.Close()
+
+	//Hosts at the loopback address are never proxied, so the director is
+	// reached under a name which the proxy stand-in resolves to it
+	os.Setenv("HTTPS_PROXY", "http://user:pass@"+proxy.Listener.Addr().String())
+	os.Setenv("NO_PROXY", "internal.example.com")
+	testClientThroughProxy(t, director.config("director.example.com"), seen, "director.example.com", director)
+
+	client, err := NewClient(director.config("internal.example.com"), testLogger())
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	req, _ := http.NewRequest("GET", client.path("/info"), nil)
+	proxyURL, err := client.client.Transport.(*http.Transport).Proxy(req)
+	if err != nil || proxyURL != nil {
+		t.Errorf("Expected a director in NO_PROXY to be reached directly, got proxy %v (%v)", proxyURL, err)
+	}
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	director := newFakeDirector("uaa", false)
+	defer director.close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	defer listener.Close()
+
+	seen := &proxyLog{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(conn, "user", "pass", seen)
+		}
+	}()
+
+	cfg := director.config("127.0.0.1")
+	cfg.Proxy = "socks5://user:pass@" + listener.Addr().String()
+	testClientThroughProxy(t, cfg, seen, "127.0.0.1", director)
+}
+
+//serveSOCKS5 handles one connection to a SOCKS5 proxy stand-in, which requires
+// the given username and password and only supports CONNECT
+func serveSOCKS5(conn net.Conn, username, password string, seen *proxyLog) {
+	defer conn.Close()
+
+	//Greeting: version, then the offered auth methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil || header[0] != 5 {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 2})
+
+	//Username and password auth, as in RFC 1929
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	user := make([]byte, header[1])
+	io.ReadFull(conn, user)
+	passLen := make([]byte, 1)
+	io.ReadFull(conn, passLen)
+	pass := make([]byte, passLen[0])
+	io.ReadFull(conn, pass)
+	if string(user) != username || string(pass) != password {
+		conn.Write([]byte{1, 1})
+		return
+	}
+	conn.Write([]byte{1, 0})
+
+	//Request: version, command, reserved, then the address
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil || request[1] != 1 {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		addr := make([]byte, 4)
+		io.ReadFull(conn, addr)
+		host = net.IP(addr).String()
+	case 3:
+		addrLen := make([]byte, 1)
+		io.ReadFull(conn, addrLen)
+		addr := make([]byte, addrLen[0])
+		io.ReadFull(conn, addr)
+		host = string(addr)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	seen.add(target)
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestProxyValidation(t *testing.T) {
+	for _, proxyURL := range []string{"ftp://10.0.0.1:21", "socks4://10.0.0.1:1080", "http://"} {
+		_, err := NewClient(config.BOSH{URL: "10.0.0.6", Proxy: proxyURL}, testLogger())
+		if err == nil {
+			t.Errorf("Expected proxy URL `%s' to be rejected", proxyURL)
+		}
+	}
+}
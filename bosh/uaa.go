@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"strings"
 	"time"
@@ -19,7 +18,29 @@ type UAA struct {
 	Logger *log.Logger
 }
 
-func (c *UAA) do(values url.Values) (*UAAAuthResponse, error) {
+//UAAError is an OAuth error returned by UAA, such as invalid_grant
+type UAAError struct {
+	StatusCode  int
+	Code        string
+	Description string
+}
+
+func (e *UAAError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("Could not authenticate: Status %d", e.StatusCode)
+	}
+
+	if e.Description == "" {
+		return fmt.Sprintf("Could not authenticate: %s", e.Code)
+	}
+
+	return fmt.Sprintf("Could not authenticate: %s (%s)", e.Code, e.Description)
+}
+
+//do requests a token with the given grant, authenticating as the client with
+// HTTP basic auth
+func (c *UAA) do(clientID, clientSecret string, values url.Values) (*UAAAuthResponse, error) {
+	values.Set("client_id", clientID)
 	req, err := http.NewRequest(
 		"POST",
 		fmt.Sprintf("%s/oauth/token", c.URL),
@@ -32,25 +53,36 @@ func (c *UAA) do(values url.Values) (*UAAAuthResponse, error) {
 
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
 
-	reqDump, err := httputil.DumpRequestOut(req, true)
-	if err != nil {
-		return nil, err
-	}
-	c.Logger.Debug(string(reqDump))
+	//The form, the basic auth header, and the response all hold credentials, so
+	// only what was asked for and how UAA answered are logged
+	c.Logger.Debug("%s %s (%s grant as client `%s')", req.Method, req.URL, values.Get("grant_type"), clientID)
 	resp, err := c.Client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	respDump, err := httputil.DumpResponse(resp, true)
+	c.Logger.Debug("%s from %s", resp.Status, req.URL)
+
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	c.Logger.Debug(string(respDump))
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Could not authenticate: Status %d", resp.StatusCode)
+		uaaErr := &UAAError{StatusCode: resp.StatusCode}
+		errOut := struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}{}
+		//Not every failure has an OAuth error body, in which case only the status
+		// can be reported
+		if json.Unmarshal(body, &errOut) == nil {
+			uaaErr.Code = errOut.Error
+			uaaErr.Description = errOut.ErrorDescription
+		}
+		return nil, uaaErr
 	}
 
 	type response struct {
@@ -60,13 +92,7 @@ func (c *UAA) do(values url.Values) (*UAAAuthResponse, error) {
 	}
 
 	r := response{}
-	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(&r)
-	if err != nil {
-		return nil, err
-	}
-
-	_, err = ioutil.ReadAll(resp.Body)
+	err = json.Unmarshal(body, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -88,9 +114,33 @@ func (c *UAA) ClientCredentials(
 	clientID,
 	clientSecret string) (*UAAAuthResponse, error) {
 
-	return c.do(url.Values{
-		"grant_type":    []string{"client_credentials"},
-		"client_id":     []string{clientID},
-		"client_secret": []string{clientSecret},
+	return c.do(clientID, clientSecret, url.Values{
+		"grant_type": []string{"client_credentials"},
+	})
+}
+
+//Password logs into a user account through the given client
+func (c *UAA) Password(
+	clientID,
+	clientSecret,
+	username,
+	password string) (*UAAAuthResponse, error) {
+
+	return c.do(clientID, clientSecret, url.Values{
+		"grant_type": []string{"password"},
+		"username":   []string{username},
+		"password":   []string{password},
+	})
+}
+
+//RefreshToken exchanges a refresh token for a new access token
+func (c *UAA) RefreshToken(
+	clientID,
+	clientSecret,
+	refreshToken string) (*UAAAuthResponse, error) {
+
+	return c.do(clientID, clientSecret, url.Values{
+		"grant_type":    []string{"refresh_token"},
+		"refresh_token": []string{refreshToken},
 	})
 }
@@ -3,7 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
-	"regexp"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/starkandwayne/signalfire/bosh"
@@ -53,37 +54,100 @@ func main() {
 			logger.Fatal("Error initializing BOSH client for URL `%s': %s", t.URL, err)
 		}
 
-		err = b.Connect()
-		if err != nil {
-			logger.Fatal("Could not start BOSH client: %s", err)
-		}
-
 		boshes = append(boshes, core.BOSH{
-			Client:       b,
-			PollInterval: time.Duration(t.PollInterval) * time.Second,
+			Client:                b,
+			PollInterval:          time.Duration(t.PollInterval) * time.Second,
+			InstancesPollInterval: time.Duration(t.InstancesPollInterval) * time.Second,
 		})
 	}
 
 	//Configure the core logic orchestration
+	rules, err := core.NewCollationRules(cfg.Collation.Rules)
+	if err != nil {
+		logger.Fatal("Error parsing collation rules: %s", err)
+	}
+
+	collationMode, err := core.ParseCollationMode(cfg.Collation.Mode)
+	if err != nil {
+		logger.Fatal("Error parsing collation mode: %s", err)
+	}
+
+	pipelines, err := core.NewPipelines(cfg.Collation.Pipelines)
+	if err != nil {
+		logger.Fatal("Error parsing collation pipelines: %s", err)
+	}
+
+	policies, err := core.NewPolicies(cfg.Policies)
+	if err != nil {
+		logger.Fatal("Error parsing policies: %s", err)
+	}
+
+	versionSchemes, err := core.NewVersionSchemes(cfg.Versions)
+	if err != nil {
+		logger.Fatal("Error parsing version schemes: %s", err)
+	}
+
+	catalogSource, err := core.NewCatalogSource(cfg.Catalog, versionSchemes)
+	if err != nil {
+		logger.Fatal("Error parsing release catalog: %s", err)
+	}
+
+	var catalog *core.Catalog
+	if catalogSource != nil {
+		catalog = core.NewCatalog(catalogSource, &logger)
+		catalog.Start(time.Duration(cfg.Catalog.RefreshInterval) * time.Second)
+	}
+
+	var advisoryFeed *core.AdvisoryFeed
+	if cfg.Advisories.Path != "" {
+		advisoryFeed = core.NewAdvisoryFeed(cfg.Advisories.Path, &logger)
+		//The feed is read now, so that a bad feed stops startup, and then re-read
+		// on every refresh interval
+		err = advisoryFeed.Refresh()
+		if err != nil {
+			logger.Fatal("Error loading advisory feed: %s", err)
+		}
+		advisoryFeed.Start(time.Duration(cfg.Advisories.RefreshInterval) * time.Second)
+	}
+
 	cache := core.NewCache()
 	collator := core.NewCollator(&logger)
-	//TODO: Make the rules configurable
-	collator.AddRule(core.DeploymentRegexCaptureRule{Match: regexp.MustCompile(`.*-(.*)`)})
-	collator.AddRule(core.DeploymentRegexCaptureRule{Match: regexp.MustCompile(`(.*)`)})
+	collator.SetMode(collationMode)
+	collator.SetVersionSchemes(versionSchemes)
+	collator.SetCatalog(catalog)
+	collator.SetAdvisoryFeed(advisoryFeed)
+	for _, rule := range rules {
+		collator.AddRule(rule)
+	}
+	for _, pipeline := range pipelines {
+		collator.AddPipeline(pipeline)
+	}
+	for _, policy := range policies {
+		collator.AddPolicy(policy)
+	}
 	collator.WatchAsync(cache)
 
-	scheduler := core.Scheduler{
-		Boshes: boshes,
-		Cache:  cache,
-		Logger: &logger,
-	}
+	scheduler := core.NewScheduler(boshes, cache, &logger)
 	scheduler.Start()
 
+	//Stop polling on shutdown, so that the BOSH clients stop renewing their
+	// authentication
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		sig := <-signals
+		logger.Info("Received %s, so shutting down", sig)
+		scheduler.Stop()
+		os.Exit(0)
+	}()
+
 	//Start up the HTTP API
 	serv, err := server.New(cfg.Server, server.Components{
-		Collator: collator,
-		Cache:    cache,
-		Log:      &logger,
+		Collator:   collator,
+		Cache:      cache,
+		Catalog:    catalog,
+		Advisories: advisoryFeed,
+		Log:        &logger,
 	})
 	if err != nil {
 		logger.Fatal("Could not initialize server: %s", err)
@@ -16,21 +16,40 @@ const (
 )
 
 type Config struct {
-	Targets []BOSH `yaml:"targets"`
-	Server  Server `yaml:"server"`
-	Log     Log    `yaml:"log"`
+	Targets    []BOSH     `yaml:"targets"`
+	Server     Server     `yaml:"server"`
+	Log        Log        `yaml:"log"`
+	Collation  Collation  `yaml:"collation"`
+	Versions   Versions   `yaml:"versions"`
+	Catalog    Catalog    `yaml:"catalog"`
+	Advisories Advisories `yaml:"advisories"`
+	Policies   []Policy   `yaml:"policies"`
 }
 type BOSH struct {
-	URL                string `yaml:"url"`
-	CACert             string `yaml:"ca_cert"`
-	PollInterval       uint   `yaml:"poll_interval"` //in seconds
-	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
-	Auth               struct {
-		ClientID     string `yaml:"client_id"`
-		ClientSecret string `yaml:"client_secret"`
-	} `yaml:"auth"`
+	URL                   string   `yaml:"url"`
+	CACert                string   `yaml:"ca_cert"`
+	PollInterval          uint     `yaml:"poll_interval"`           //in seconds
+	InstancesPollInterval uint     `yaml:"instances_poll_interval"` //in seconds; 0 disables fetching instances
+	InsecureSkipVerify    bool     `yaml:"insecure_skip_verify"`
+	Proxy                 string   `yaml:"proxy"`           //http, https, or socks5 URL; defaults to the proxy environment variables
+	RequestTimeout        uint     `yaml:"request_timeout"` //in seconds; defaults to 30
+	Auth                  BOSHAuth `yaml:"auth"`
 }
 
+//BOSHAuth holds the credentials for a director. A UAA client is used unless a
+// username is given, in which case that user account is logged into through
+// the client, which defaults to the one that the BOSH CLI uses.
+type BOSHAuth struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+}
+
+//DefaultBOSHClientID is the UAA client that user accounts are logged into
+// through if no other client is given. It has no secret.
+const DefaultBOSHClientID = "bosh_cli"
+
 type Server struct {
 	TLS struct {
 		Certificate string `yaml:"certificate"`
@@ -53,6 +72,190 @@ type Log struct {
 	Level string `yaml:"level"`
 }
 
+type Collation struct {
+	//Mode is either "first_match", where a deployment is put in the group of
+	// the first matching rule of each dimension, or "tags", where every
+	// matching rule adds the deployment to a group
+	Mode  string          `yaml:"mode"`
+	Rules []CollationRule `yaml:"rules"`
+	//Pipelines give an order to the deployments within groups, such as
+	// dev, then staging, then prod
+	Pipelines []Pipeline `yaml:"pipelines"`
+}
+
+type Pipeline struct {
+	//Group is a regex. The pipeline applies to groups with a matching name.
+	Group string `yaml:"group"`
+	//Dimension is the tag dimension of the groups which this pipeline applies
+	// to. Defaults to the default "group" dimension.
+	Dimension string `yaml:"dimension"`
+	//Stages are given in promotion order, with the first stage being the one
+	// which receives new versions first
+	Stages []PipelineStage `yaml:"stages"`
+}
+
+//PipelineStage matches the deployments of a group which belong to the stage.
+// At least one of DeploymentName or DirectorName must be given, and all that
+// are given must match.
+type PipelineStage struct {
+	Name string `yaml:"name"`
+	//DeploymentName is a regex to match against the deployment name
+	DeploymentName string `yaml:"deployment_name"`
+	//DirectorName is a regex to match against the director name
+	DirectorName string `yaml:"director_name"`
+}
+
+//Versions configures how the versions of each release are ordered
+type Versions struct {
+	//DefaultScheme is the version scheme of releases not named in Schemes.
+	// Defaults to "legacy".
+	DefaultScheme string `yaml:"default_scheme"`
+	//Schemes maps release names to the version scheme of that release
+	Schemes map[string]string `yaml:"schemes"`
+	//StemcellScheme is the version scheme of stemcells. Defaults to "legacy".
+	StemcellScheme string `yaml:"stemcell_scheme"`
+}
+
+//Catalog configures where the latest available version of each release is
+// looked up. The catalog is disabled if Type is empty.
+type Catalog struct {
+	//Type is either "file" or "http"
+	Type string `yaml:"type"`
+	//Path is used by the file type, and is the path to a YAML or JSON file
+	// mapping release names to their latest versions under a `releases' key
+	Path string `yaml:"path"`
+	//URL is used by the http type, and is the base URL of a bosh.io-style
+	// release listing API. Defaults to https://bosh.io/api/v1/releases.
+	URL string `yaml:"url"`
+	//Releases is used by the http type, and maps release names to the path
+	// under URL that lists the versions of that release, such as
+	// github.com/cloudfoundry/bosh
+	Releases map[string]string `yaml:"releases"`
+	//RefreshInterval is in seconds, and defaults to one hour
+	RefreshInterval uint `yaml:"refresh_interval"`
+}
+
+//Policy constrains the release versions that deployments in matching groups
+// may run. At least one of MinVersion or ForbidVersion must be given.
+type Policy struct {
+	Name string `yaml:"name"`
+	//Group is a glob, such as prod-*. The policy applies to groups with a
+	// matching name.
+	Group string `yaml:"group"`
+	//Dimension is the tag dimension of the groups which this policy applies
+	// to. Defaults to the default "group" dimension.
+	Dimension string `yaml:"dimension"`
+	//Release is a glob matching the names of the releases which the policy
+	// applies to. Defaults to every release.
+	Release string `yaml:"release"`
+	//MinVersion is the oldest version allowed, compared with the version scheme
+	// of the release
+	MinVersion string `yaml:"min_version"`
+	//ForbidVersion is a regex. Versions matching it are not allowed, such as
+	// "rc" to forbid release candidates.
+	ForbidVersion string `yaml:"forbid_version"`
+}
+
+//Advisories configures the security advisory feed. Matching advisories is
+// disabled if Path is empty.
+type Advisories struct {
+	//Path is the path to a YAML or JSON advisory feed file
+	Path string `yaml:"path"`
+	//RefreshInterval is in seconds, and defaults to five minutes
+	RefreshInterval uint `yaml:"refresh_interval"`
+}
+
+const (
+	CatalogTypeFile = "file"
+	CatalogTypeHTTP = "http"
+)
+
+//DefaultCatalogURL is the release listing API of bosh.io
+const DefaultCatalogURL = "https://bosh.io/api/v1/releases"
+
+const (
+	VersionSchemeLegacy  = "legacy"
+	VersionSchemeSemver  = "semver"
+	VersionSchemeBOSHDev = "bosh_dev"
+	VersionSchemeCalver  = "calver"
+)
+
+const (
+	CollationModeFirstMatch = "first_match"
+	CollationModeTags       = "tags"
+)
+
+const (
+	CollationRuleTypeDeploymentRegex = "deployment_regex"
+	CollationRuleTypeDirectorRegex   = "director_regex"
+	CollationRuleTypeNameMap         = "name_map"
+	CollationRuleTypeRelease         = "release"
+	CollationRuleTypePredicate       = "predicate"
+	CollationRuleTypeManifestTag     = "manifest_tag"
+)
+
+//CollationRule configures one rule for sorting deployments into groups. Which
+// fields are meaningful depends on the Type of the rule. Rules can also be
+// given as JSON to the collation dry-run API.
+type CollationRule struct {
+	Type string `yaml:"type" json:"type"`
+	//Dimension is the tag axis that this rule sorts deployments along. Rules
+	// without a dimension sort into the default "group" dimension.
+	Dimension string `yaml:"dimension" json:"dimension"`
+	//Match is a regular expression used by the deployment_regex and
+	// director_regex types. The first capturing group becomes the group name.
+	Match string `yaml:"match" json:"match"`
+	//Map is used by the name_map type, and maps deployment names to group
+	// names.
+	Map map[string]string `yaml:"map" json:"map"`
+	//Release is used by the release type, and is the name of the release which
+	// a deployment must contain to match.
+	Release string `yaml:"release" json:"release"`
+	//Group is the group name given by the release type, where it defaults to
+	// the name of the release. For the predicate type, it is a Go text/template
+	// which is executed against the matched deployment.
+	Group string `yaml:"group" json:"group"`
+	//Key is used by the manifest_tag type, and is the key of the manifest tag
+	// whose value becomes the group name
+	Key string `yaml:"key" json:"key"`
+	//Fallback is used by the manifest_tag type as the group name for
+	// deployments without the tag. If empty, such deployments do not match.
+	Fallback string `yaml:"fallback" json:"fallback"`
+	//When is used by the predicate type, which only matches deployments for
+	// which the predicate holds true.
+	When *CollationPredicate `yaml:"when" json:"when"`
+}
+
+//CollationPredicate is a boolean condition about a deployment. Exactly one of
+// its fields should be set.
+type CollationPredicate struct {
+	AllOf []CollationPredicate `yaml:"all_of" json:"all_of"`
+	AnyOf []CollationPredicate `yaml:"any_of" json:"any_of"`
+	Not   *CollationPredicate  `yaml:"not" json:"not"`
+	//DeploymentName is a regex to match against the deployment name
+	DeploymentName string `yaml:"deployment_name" json:"deployment_name"`
+	//DirectorName is a regex to match against the director name
+	DirectorName string `yaml:"director_name" json:"director_name"`
+	//DirectorUUID must exactly equal the UUID of the director
+	DirectorUUID   string                     `yaml:"director_uuid" json:"director_uuid"`
+	HasRelease     string                     `yaml:"has_release" json:"has_release"`
+	ReleaseVersion *CollationVersionPredicate `yaml:"release_version" json:"release_version"`
+}
+
+//CollationVersionPredicate matches deployments which contain the named release
+// at a version matching the Match regex
+type CollationVersionPredicate struct {
+	Release string `yaml:"release" json:"release"`
+	Match   string `yaml:"match" json:"match"`
+}
+
+//DefaultCollationRules are used if no collation rules are given in the
+// configuration
+var DefaultCollationRules = []CollationRule{
+	{Type: CollationRuleTypeDeploymentRegex, Match: `.*-(.*)`},
+	{Type: CollationRuleTypeDeploymentRegex, Match: `(.*)`},
+}
+
 type WebDevMapping struct {
 	File      string `yaml:"file"`
 	ServePath string `yaml:"serve_path"`
@@ -68,7 +271,9 @@ var DefaultConfig = Config{
 			Password: "password",
 		},
 	},
-	Log: Log{Level: "info"},
+	Log:       Log{Level: "info"},
+	Collation: Collation{Mode: CollationModeFirstMatch},
+	Versions:  Versions{DefaultScheme: VersionSchemeLegacy, StemcellScheme: VersionSchemeLegacy},
 }
 
 func Parse(r io.Reader) (*Config, error) {
@@ -78,11 +283,34 @@ func Parse(r io.Reader) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Error decoding config yaml: %s", err)
 	}
-	for _, t := range ret.Targets {
-		if t.PollInterval == 0 {
-			t.PollInterval = 30
+	for i := range ret.Targets {
+		if ret.Targets[i].PollInterval == 0 {
+			ret.Targets[i].PollInterval = 30
+		}
+		auth := &ret.Targets[i].Auth
+		if auth.Username != "" && auth.ClientID == "" {
+			auth.ClientID = DefaultBOSHClientID
 		}
 	}
 	ret.Log.Level = strings.ToLower(ret.Log.Level)
+	ret.Collation.Mode = strings.ToLower(ret.Collation.Mode)
+	ret.Versions.DefaultScheme = strings.ToLower(ret.Versions.DefaultScheme)
+	ret.Versions.StemcellScheme = strings.ToLower(ret.Versions.StemcellScheme)
+	ret.Catalog.Type = strings.ToLower(ret.Catalog.Type)
+	if ret.Catalog.URL == "" {
+		ret.Catalog.URL = DefaultCatalogURL
+	}
+	if ret.Catalog.RefreshInterval == 0 {
+		ret.Catalog.RefreshInterval = 3600
+	}
+	if ret.Advisories.RefreshInterval == 0 {
+		ret.Advisories.RefreshInterval = 300
+	}
+	for release, scheme := range ret.Versions.Schemes {
+		ret.Versions.Schemes[release] = strings.ToLower(scheme)
+	}
+	if len(ret.Collation.Rules) == 0 {
+		ret.Collation.Rules = DefaultCollationRules
+	}
 	return &ret, nil
 }
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDefaultsPollInterval(t *testing.T) {
+	cfg, err := Parse(strings.NewReader(`
+targets:
+- url: https://10.0.0.6:25555
+- url: https://10.0.0.7:25555
+  poll_interval: 60
+`))
+	if err != nil {
+		t.Fatalf("Could not parse config: %s", err)
+	}
+
+	if got := cfg.Targets[0].PollInterval; got != 30 {
+		t.Errorf("Expected the poll interval to default to 30, got %d", got)
+	}
+	if got := cfg.Targets[1].PollInterval; got != 60 {
+		t.Errorf("Expected the given poll interval of 60, got %d", got)
+	}
+}
+
+func TestParseDefaultsClientIDOfUsers(t *testing.T) {
+	cfg, err := Parse(strings.NewReader(`
+targets:
+- url: https://10.0.0.6:25555
+  auth: {username: admin, password: hunter2}
+- url: https://10.0.0.7:25555
+  auth: {username: admin, password: hunter2, client_id: ops, client_secret: shh}
+- url: https://10.0.0.8:25555
+  auth: {client_id: signalfire, client_secret: shh}
+`))
+	if err != nil {
+		t.Fatalf("Could not parse config: %s", err)
+	}
+
+	for i, want := range []string{DefaultBOSHClientID, "ops", "signalfire"} {
+		if got := cfg.Targets[i].Auth.ClientID; got != want {
+			t.Errorf("Target %d: Expected client `%s', got `%s'", i, want, got)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/starkandwayne/signalfire/log"
+	"gopkg.in/yaml.v2"
+)
+
+//Advisory is a security advisory affecting some versions of a release
+type Advisory struct {
+	ID       string
+	Release  string
+	Severity string
+	//Affected holds the version ranges which the advisory applies to. If it is
+	// empty, every version before FixedVersion is affected.
+	Affected     []VersionRange
+	FixedVersion string
+}
+
+//Affects returns true if the given version of the release is affected by the
+// advisory, comparing versions with the given scheme. Versions which the
+// scheme cannot order are never affected.
+func (a Advisory) Affects(release, version string, scheme VersionScheme) bool {
+	if release != a.Release || !scheme.Parses(version) {
+		return false
+	}
+
+	if len(a.Affected) == 0 {
+		return a.FixedVersion != "" && scheme.Compare(version, a.FixedVersion) < 0
+	}
+
+	for _, r := range a.Affected {
+		if r.Contains(version, scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//VersionRange is a set of constraints, such as ">= 1.2.0, < 1.4.1", which a
+// version must all satisfy to be in the range
+type VersionRange struct {
+	constraints []versionConstraint
+}
+
+type versionConstraint struct {
+	op      string
+	version string
+}
+
+//rangeOperators are ordered so that no operator is checked before another
+// that it is a prefix of
+var rangeOperators = []string{">=", "<=", "!=", ">", "<", "="}
+
+//ParseVersionRange parses comma-separated constraints, each an operator out of
+// >=, <=, !=, >, <, or =, followed by a version. A version without an
+// operator must match exactly.
+func ParseVersionRange(s string) (VersionRange, error) {
+	ret := VersionRange{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return VersionRange{}, fmt.Errorf("Empty constraint in version range `%s'", s)
+		}
+
+		constraint := versionConstraint{op: "="}
+		for _, op := range rangeOperators {
+			if strings.HasPrefix(part, op) {
+				constraint.op = op
+				part = strings.TrimPrefix(part, op)
+				break
+			}
+		}
+		constraint.version = strings.TrimSpace(part)
+		if constraint.version == "" {
+			return VersionRange{}, fmt.Errorf("Constraint with no version in version range `%s'", s)
+		}
+		ret.constraints = append(ret.constraints, constraint)
+	}
+
+	return ret, nil
+}
+
+//Contains returns true if the version satisfies every constraint of the range
+func (r VersionRange) Contains(version string, scheme VersionScheme) bool {
+	for _, c := range r.constraints {
+		diff := scheme.Compare(version, c.version)
+		var ok bool
+		switch c.op {
+		case ">=":
+			ok = diff >= 0
+		case "<=":
+			ok = diff <= 0
+		case "!=":
+			ok = diff != 0
+		case ">":
+			ok = diff > 0
+		case "<":
+			ok = diff < 0
+		default:
+			ok = diff == 0
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+//advisoryFeedEntry is the form of an advisory in the feed file
+type advisoryFeedEntry struct {
+	ID           string   `yaml:"id"`
+	Release      string   `yaml:"release"`
+	Severity     string   `yaml:"severity"`
+	Affected     []string `yaml:"affected"`
+	FixedVersion string   `yaml:"fixed_version"`
+}
+
+//ParseAdvisories parses an advisory feed, which is a YAML or JSON document with
+// a list of advisories under an `advisories' key
+func ParseAdvisories(contents []byte) ([]Advisory, error) {
+	feed := struct {
+		Advisories []advisoryFeedEntry `yaml:"advisories"`
+	}{}
+	//JSON is valid YAML, so this handles both
+	err := yaml.Unmarshal(contents, &feed)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]Advisory, 0, len(feed.Advisories))
+	for i, entry := range feed.Advisories {
+		if entry.ID == "" || entry.Release == "" {
+			return nil, fmt.Errorf("Advisory at index %d: An `id' and `release' are required", i)
+		}
+
+		if len(entry.Affected) == 0 && entry.FixedVersion == "" {
+			return nil, fmt.Errorf("Advisory `%s': One of `affected' or `fixed_version' is required", entry.ID)
+		}
+
+		toAdd := Advisory{
+			ID:           entry.ID,
+			Release:      entry.Release,
+			Severity:     entry.Severity,
+			FixedVersion: entry.FixedVersion,
+		}
+		for _, affected := range entry.Affected {
+			r, err := ParseVersionRange(affected)
+			if err != nil {
+				return nil, fmt.Errorf("Advisory `%s': %s", entry.ID, err)
+			}
+			toAdd.Affected = append(toAdd.Affected, r)
+		}
+
+		ret = append(ret, toAdd)
+	}
+
+	return ret, nil
+}
+
+//AdvisoryFeed holds the advisories last read from a feed file
+type AdvisoryFeed struct {
+	Path       string
+	advisories []Advisory
+	loadedAt   time.Time
+	lastErr    error
+	lock       sync.RWMutex
+	logger     *log.Logger
+}
+
+func NewAdvisoryFeed(path string, logger *log.Logger) *AdvisoryFeed {
+	return &AdvisoryFeed{Path: path, logger: logger}
+}
+
+//Refresh reads the feed file. On failure, the advisories from the last
+// successful read are kept.
+func (f *AdvisoryFeed) Refresh() error {
+	contents, err := ioutil.ReadFile(f.Path)
+	var advisories []Advisory
+	if err == nil {
+		advisories, err = ParseAdvisories(contents)
+		if err != nil {
+			err = fmt.Errorf("Could not parse advisory feed `%s': %s", f.Path, err)
+		}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.lastErr = err
+	if err != nil {
+		return err
+	}
+
+	f.advisories = advisories
+	f.loadedAt = time.Now()
+	return nil
+}
+
+//Start reads the feed on every interval. The first read is after one interval,
+// so call Refresh beforehand to read it now.
+func (f *AdvisoryFeed) Start(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			f.refreshAndLog()
+		}
+	}()
+}
+
+func (f *AdvisoryFeed) refreshAndLog() {
+	err := f.Refresh()
+	if err != nil {
+		f.logger.Error("Could not refresh advisory feed: %s", err)
+		return
+	}
+
+	f.logger.Debug("Refreshed advisory feed from `%s'", f.Path)
+}
+
+//GetAdvisories returns the advisories last read from the feed. It is safe to
+// call on a nil AdvisoryFeed, which has no advisories.
+func (f *AdvisoryFeed) GetAdvisories() []Advisory {
+	if f == nil {
+		return nil
+	}
+
+	f.lock.RLock()
+	ret := make([]Advisory, len(f.advisories))
+	copy(ret, f.advisories)
+	f.lock.RUnlock()
+	return ret
+}
+
+//Status returns when the feed was last read successfully, which is zero if it
+// never has been, and the error of the last read, if it failed
+func (f *AdvisoryFeed) Status() (time.Time, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.loadedAt, f.lastErr
+}
+
+//AdvisoryMatch lists the deployments affected by an advisory
+type AdvisoryMatch struct {
+	Advisory    Advisory
+	Deployments []AdvisoryDeployment
+}
+
+type AdvisoryDeployment struct {
+	ID      string
+	Version string
+}
+
+//SetAdvisoryFeed sets the feed that release versions are matched against
+func (c *Collator) SetAdvisoryFeed(feed *AdvisoryFeed) {
+	c.lock.Lock()
+	c.advisories = feed
+	c.lock.Unlock()
+}
+
+//GetAdvisoryMatches matches every advisory in the feed against the deployments
+// of all directors. Advisories which affect no deployment are included with no
+// deployments.
+func (c *Collator) GetAdvisoryMatches() []AdvisoryMatch {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	advisories := c.advisories.GetAdvisories()
+	ret := make([]AdvisoryMatch, 0, len(advisories))
+	for _, advisory := range advisories {
+		toAdd := AdvisoryMatch{Advisory: advisory, Deployments: []AdvisoryDeployment{}}
+		scheme := c.schemes.For(advisory.Release)
+		for _, deployments := range c.directors {
+			for id, deployment := range deployments {
+				for _, release := range deployment.Releases {
+					if advisory.Affects(release.Name, release.Version, scheme) {
+						toAdd.Deployments = append(toAdd.Deployments, AdvisoryDeployment{
+							ID:      id,
+							Version: release.Version,
+						})
+					}
+				}
+			}
+		}
+
+		sort.Slice(toAdd.Deployments, func(i, j int) bool {
+			return toAdd.Deployments[i].ID < toAdd.Deployments[j].ID
+		})
+		ret = append(ret, toAdd)
+	}
+
+	return ret
+}
+
+//markAdvisories sets the Advisories of every release version of the group. It
+// must be called with the lock held, and only on copies of groups, as the feed
+// may change without the collator knowing.
+func (c *Collator) markAdvisories(group *CollationDeploymentGroup, advisories []Advisory) {
+	for i := range group.Releases {
+		release := &group.Releases[i]
+		scheme := c.schemes.For(release.Name)
+		for j := range release.Versions {
+			version := &release.Versions[j]
+			version.Advisories = nil
+			for _, advisory := range advisories {
+				if advisory.Affects(release.Name, version.Version, scheme) {
+					version.Advisories = append(version.Advisories, advisory)
+				}
+			}
+		}
+	}
+}
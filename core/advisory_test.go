@@ -0,0 +1,113 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdvisoryAffects(t *testing.T) {
+	advisories, err := ParseAdvisories([]byte(`
+advisories:
+- id: CVE-2020-5400
+  release: uaa
+  severity: high
+  affected: [">= 70.0.0, < 74.12.0", "= 69.0.0"]
+  fixed_version: 74.12.0
+- id: USN-4000-1
+  release: bosh
+  severity: medium
+  fixed_version: "270.9"
+`))
+	if err != nil {
+		t.Fatalf("ParseAdvisories: %s", err)
+	}
+
+	tests := []struct {
+		advisory int
+		release  string
+		version  string
+		affected bool
+	}{
+		{0, "uaa", "70.0.0", true},
+		{0, "uaa", "74.11.9", true},
+		{0, "uaa", "74.12.0", false},
+		{0, "uaa", "69.0.0", true},
+		{0, "uaa", "69.1.0", false},
+		{0, "bosh", "72.0.0", false},
+		{1, "bosh", "270.2", true},
+		{1, "bosh", "270.9", false},
+		{1, "bosh", "271.0", false},
+	}
+
+	for _, test := range tests {
+		advisory := advisories[test.advisory]
+		got := advisory.Affects(test.release, test.version, LegacyVersionScheme{})
+		if got != test.affected {
+			t.Errorf("%s: Affects(%s, %s) = %t, want %t",
+				advisory.ID, test.release, test.version, got, test.affected)
+		}
+	}
+}
+
+func TestParseAdvisoriesRejectsInvalidFeeds(t *testing.T) {
+	feeds := []string{
+		`{"advisories": [{"release": "uaa", "fixed_version": "1.0"}]}`,
+		`{"advisories": [{"id": "CVE-1", "release": "uaa"}]}`,
+		`{"advisories": [{"id": "CVE-1", "release": "uaa", "affected": [">= 1.0,"]}]}`,
+		`{"advisories": [{"id": "CVE-1", "release": "uaa", "affected": ["<"]}]}`,
+	}
+
+	for _, feed := range feeds {
+		if _, err := ParseAdvisories([]byte(feed)); err == nil {
+			t.Errorf("Expected an error parsing %s", feed)
+		}
+	}
+}
+
+func TestAdvisoryFeedRereadsOnEveryInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "advisories")
+	if err != nil {
+		t.Fatalf("Could not make temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "feed.yml")
+	write := func(id string) {
+		feed := fmt.Sprintf("advisories:\n- {id: %s, release: uaa, fixed_version: 74.0.0}\n", id)
+		if err := ioutil.WriteFile(path, []byte(feed), 0644); err != nil {
+			t.Fatalf("Could not write feed: %s", err)
+		}
+	}
+	ids := func(feed *AdvisoryFeed) string {
+		ret := []string{}
+		for _, advisory := range feed.GetAdvisories() {
+			ret = append(ret, advisory.ID)
+		}
+		return strings.Join(ret, ",")
+	}
+
+	write("CVE-1")
+	feed := NewAdvisoryFeed(path, testLogger())
+	if err := feed.Refresh(); err != nil {
+		t.Fatalf("Could not read feed: %s", err)
+	}
+
+	//Start does not read the feed again until the first interval has passed
+	write("CVE-2")
+	feed.Start(100 * time.Millisecond)
+	if got := ids(feed); got != "CVE-1" {
+		t.Errorf("Expected the feed not to be re-read at once, got %s", got)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ids(feed) != "CVE-2" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the feed to be re-read, got %s", ids(feed))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
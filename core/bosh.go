@@ -11,4 +11,7 @@ import (
 type BOSH struct {
 	Client       *bosh.Client
 	PollInterval time.Duration
+	//InstancesPollInterval is how often to fetch the state of the instances of
+	// each deployment. They are not fetched if it is zero.
+	InstancesPollInterval time.Duration
 }
@@ -1,22 +1,168 @@
 package core
 
-import "sync"
+import (
+	"sync"
+	"time"
+
+	"github.com/starkandwayne/signalfire/bosh"
+)
 
 type Cache struct {
 	data      []CacheEnvironment
 	lock      sync.RWMutex
-	listeners []chan bool
+	listeners []chan string
 }
 
+//Statuses of the connection to a director
+const (
+	DirectorStatusOK          = "ok"
+	DirectorStatusUnreachable = "unreachable"
+)
+
 type CacheEnvironment struct {
-	Name        string
+	Name string
+	//UUID is empty if the director has never been reached, in which case the
+	// environment is only known by its URL
 	UUID        string
+	URL         string
 	Deployments CacheDeployments
+	Director    CacheDirector
+	Scrape      CacheScrapeStatus
+}
+
+func (e CacheEnvironment) Copy() CacheEnvironment {
+	return CacheEnvironment{
+		Name:        e.Name,
+		UUID:        e.UUID,
+		URL:         e.URL,
+		Deployments: e.Deployments.Copy(),
+		Director:    e.Director.Copy(),
+		Scrape:      e.Scrape,
+	}
+}
+
+//CacheScrapeStatus describes how fresh the data from a director is. When a
+// scrape fails, the data from the last successful one is kept.
+type CacheScrapeStatus struct {
+	Status string
+	//LastSuccessAt is zero if the director has never been scraped successfully
+	LastSuccessAt time.Time
+	LastAttemptAt time.Time
+	//LastError is empty if the last attempt succeeded
+	LastError           string
+	ConsecutiveFailures int
+}
+
+//CacheDirector describes the BOSH director of an environment
+type CacheDirector struct {
+	Version string
+	CPI     string
+	//Features maps director feature names to whether they are enabled
+	Features map[string]bool
+	//Configs holds the two most recent versions of every cloud, runtime and
+	// CPI config of the director
+	Configs CacheConfigs
+}
+
+func (d CacheDirector) Copy() CacheDirector {
+	ret := d
+	if d.Features != nil {
+		ret.Features = make(map[string]bool, len(d.Features))
+		for k, v := range d.Features {
+			ret.Features[k] = v
+		}
+	}
+	ret.Configs = d.Configs.Copy()
+
+	return ret
+}
+
+//CacheConfig is a version of a named config of some type on a director
+type CacheConfig struct {
+	Type string
+	Name string
+	ID   string
+	//CreatedAt is zero if the director did not give a valid timestamp
+	CreatedAt time.Time
+	//Content is the config YAML, with secrets redacted. It is empty if the
+	// config could not be parsed for redaction, in which case Unparsable is true.
+	Content    string
+	Unparsable bool
+}
+
+//CacheConfigs are ordered by type and name, and then newest first
+type CacheConfigs []CacheConfig
+
+func (configs CacheConfigs) Copy() CacheConfigs {
+	if configs == nil {
+		return nil
+	}
+
+	ret := make(CacheConfigs, len(configs))
+	copy(ret, configs)
+	return ret
+}
+
+//Latest returns the most recent version of each config
+func (configs CacheConfigs) Latest() CacheConfigs {
+	ret := CacheConfigs{}
+	for i, config := range configs {
+		if i > 0 && configs[i-1].Type == config.Type && configs[i-1].Name == config.Name {
+			continue
+		}
+		ret = append(ret, config)
+	}
+
+	return ret
+}
+
+//Previous returns the version of the config before the given one, if it is
+// known
+func (configs CacheConfigs) Previous(config CacheConfig) (CacheConfig, bool) {
+	for i := range configs {
+		if configs[i].ID == config.ID && configs[i].Type == config.Type && i+1 < len(configs) {
+			next := configs[i+1]
+			if next.Type == config.Type && next.Name == config.Name {
+				return next, true
+			}
+		}
+	}
+
+	return CacheConfig{}, false
+}
+
+//addonReleases maps the names of the releases added by the runtime configs in
+// use to the name of the runtime config adding them
+func (configs CacheConfigs) addonReleases() map[string]string {
+	ret := map[string]string{}
+	for _, config := range configs.Latest() {
+		if config.Type != bosh.ConfigTypeRuntime {
+			continue
+		}
+
+		releases, err := bosh.RuntimeConfigReleases(config.Content)
+		if err != nil {
+			continue
+		}
+		for _, release := range releases {
+			ret[release] = config.Name
+		}
+	}
+
+	return ret
 }
 
 type CacheDeployment struct {
-	Name     string
-	Releases CacheReleases
+	Name      string
+	Releases  CacheReleases
+	Stemcells CacheStemcells
+	//Tags are the top-level tags of the deployment manifest
+	Tags map[string]string
+	//Instances is nil if the instances of the deployment have not been fetched
+	Instances CacheInstances
+	//Manifest is the deployment manifest YAML, with secrets redacted
+	Manifest string
+	Tasks    CacheTaskHistory
 }
 
 type CacheDeployments []CacheDeployment
@@ -25,16 +171,37 @@ func (deployments CacheDeployments) Copy() CacheDeployments {
 	ret := make(CacheDeployments, 0, len(deployments))
 	for i := range deployments {
 		ret = append(ret, CacheDeployment{
-			Name:     deployments[i].Name,
-			Releases: deployments[i].Releases.Copy(),
+			Name:      deployments[i].Name,
+			Releases:  deployments[i].Releases.Copy(),
+			Stemcells: deployments[i].Stemcells.Copy(),
+			Tags:      copyStringMap(deployments[i].Tags),
+			Instances: deployments[i].Instances.Copy(),
+			Manifest:  deployments[i].Manifest,
+			Tasks:     deployments[i].Tasks.Copy(),
 		})
 	}
 	return ret
 }
 
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	ret := make(map[string]string, len(m))
+	for k, v := range m {
+		ret[k] = v
+	}
+	return ret
+}
+
 type CacheRelease struct {
 	Name    string
 	Version string
+	//RuntimeConfig names the runtime config which adds the release to the
+	// deployment as an add-on. It is empty if the release is in the deployment
+	// manifest.
+	RuntimeConfig string
 }
 
 type CacheReleases []CacheRelease
@@ -47,50 +214,212 @@ func (releases CacheReleases) Copy() CacheReleases {
 	return ret
 }
 
+type CacheStemcell struct {
+	Name    string
+	OS      string
+	Version string
+}
+
+type CacheStemcells []CacheStemcell
+
+func (stemcells CacheStemcells) Copy() CacheStemcells {
+	if stemcells == nil {
+		return nil
+	}
+
+	ret := make(CacheStemcells, len(stemcells))
+	copy(ret, stemcells)
+	return ret
+}
+
+type CacheInstance struct {
+	InstanceGroup string
+	//Index is nil if the director did not report one
+	Index        *int
+	ID           string
+	AZ           string
+	ProcessState string
+	VMType       string
+	VMCID        string
+	IPs          []string
+}
+
+//Healthy returns true if all processes on the instance are running
+func (i CacheInstance) Healthy() bool {
+	return i.ProcessState == "running"
+}
+
+//HasVM returns false for instances which have no VM, such as those of errands
+// and of stopped instance groups
+func (i CacheInstance) HasVM() bool {
+	return i.VMCID != ""
+}
+
+type CacheInstances []CacheInstance
+
+func (instances CacheInstances) Copy() CacheInstances {
+	if instances == nil {
+		return nil
+	}
+
+	ret := make(CacheInstances, 0, len(instances))
+	for _, instance := range instances {
+		if instance.Index != nil {
+			index := *instance.Index
+			instance.Index = &index
+		}
+		instance.IPs = append([]string(nil), instance.IPs...)
+		ret = append(ret, instance)
+	}
+	return ret
+}
+
+//Health counts the healthy and unhealthy instances. Instances without a VM
+// have no processes to run, so are in neither count.
+func (instances CacheInstances) Health() (healthy, unhealthy int) {
+	for _, instance := range instances {
+		if !instance.HasVM() {
+			continue
+		}
+
+		if instance.Healthy() {
+			healthy++
+		} else {
+			unhealthy++
+		}
+	}
+
+	return
+}
+
+//CacheTask is a task that the director ran for a deployment
+type CacheTask struct {
+	ID          int
+	State       string
+	Description string
+	User        string
+	Result      string
+	FinishedAt  time.Time
+}
+
+//CacheTaskHistory summarizes the recent tasks of a deployment. Nil tasks were
+// not found among the recent tasks.
+type CacheTaskHistory struct {
+	//LastDeploy is the most recent successful deploy
+	LastDeploy *CacheTask
+	//LastFailed is the most recent task of any kind which failed, by erroring,
+	// timing out, or being cancelled
+	LastFailed *CacheTask
+	//LastDeployFailed is true if the most recent deploy did not succeed
+	LastDeployFailed bool
+}
+
+func (h CacheTaskHistory) Copy() CacheTaskHistory {
+	ret := h
+	if h.LastDeploy != nil {
+		task := *h.LastDeploy
+		ret.LastDeploy = &task
+	}
+	if h.LastFailed != nil {
+		task := *h.LastFailed
+		ret.LastFailed = &task
+	}
+
+	return ret
+}
+
 func NewCache() *Cache {
 	return &Cache{}
 }
 
-//AddListener registers a channel that will get the
-func (c *Cache) AddListener(ch chan bool) {
+//AddListener registers a channel that will get the UUID of each environment
+// as it is updated
+func (c *Cache) AddListener(ch chan string) {
 	c.lock.Lock()
 	c.listeners = append(c.listeners, ch)
 	c.lock.Unlock()
 }
 
-func (c *Cache) notifyListeners() {
+func (c *Cache) notifyListeners(uuid string) {
 	for i := range c.listeners {
-		c.listeners[i] <- true
+		c.listeners[i] <- uuid
 	}
 }
 
+//UpdateEnvironment replaces the environment with the same UUID, or URL. The
+// instances of deployments which were already in the cache are kept, as they
+// are only changed by UpdateInstances.
 func (c *Cache) UpdateEnvironment(e CacheEnvironment) {
 	c.lock.Lock()
 	idx := c.findEnvironmentIdx(cacheEnvironmentQuery{UUID: e.UUID})
+	if idx < 0 {
+		//The director may have been known only by its URL until now
+		idx = c.findEnvironmentIdx(cacheEnvironmentQuery{URL: e.URL})
+	}
 	if idx < 0 {
 		c.data = append(c.data, e)
 	} else {
+		instances := map[string]CacheInstances{}
+		for _, dep := range c.data[idx].Deployments {
+			instances[dep.Name] = dep.Instances
+		}
+		for i := range e.Deployments {
+			e.Deployments[i].Instances = instances[e.Deployments[i].Name]
+		}
 		c.data[idx] = e
 	}
 	c.lock.Unlock()
-	c.notifyListeners()
+
+	//Listeners only care about environments which can have deployments
+	if e.UUID != "" {
+		c.notifyListeners(e.UUID)
+	}
+}
+
+//UpdateInstances sets the instances of a deployment in the cache. It returns
+// false if the environment or deployment is not in the cache.
+func (c *Cache) UpdateInstances(uuid, deployment string, instances CacheInstances) bool {
+	c.lock.Lock()
+	idx := c.findEnvironmentIdx(cacheEnvironmentQuery{UUID: uuid})
+	if idx < 0 {
+		c.lock.Unlock()
+		return false
+	}
+
+	found := false
+	deployments := c.data[idx].Deployments
+	for i := range deployments {
+		if deployments[i].Name == deployment {
+			deployments[i].Instances = instances
+			found = true
+			break
+		}
+	}
+	c.lock.Unlock()
+
+	if found {
+		c.notifyListeners(uuid)
+	}
+	return found
 }
 
 type cacheEnvironmentQuery struct {
 	Name string
 	UUID string
+	URL  string
 }
 
 //Returns negative if not found
 func (c *Cache) findEnvironmentIdx(q cacheEnvironmentQuery) int {
 	ret := -1
-	if q.Name == "" && q.UUID == "" {
+	if q.Name == "" && q.UUID == "" && q.URL == "" {
 		return ret
 	}
 
 	for i, e := range c.data {
 		if (q.Name == "" || e.Name == q.Name) &&
-			(q.UUID == "" || e.UUID == q.UUID) {
+			(q.UUID == "" || e.UUID == q.UUID) &&
+			(q.URL == "" || e.URL == q.URL) {
 			ret = i
 			break
 		}
@@ -100,18 +429,39 @@ func (c *Cache) findEnvironmentIdx(q cacheEnvironmentQuery) int {
 }
 
 func (c *Cache) GetEnvironments() []CacheEnvironment {
-	ret := make([]CacheEnvironment, 0, len(c.data))
 	c.lock.RLock()
+	ret := make([]CacheEnvironment, 0, len(c.data))
 	//Deep copy each environment
 	for _, env := range c.data {
-		toAdd := CacheEnvironment{
-			Name:        env.Name,
-			UUID:        env.UUID,
-			Deployments: env.Deployments.Copy(),
-		}
+		ret = append(ret, env.Copy())
+	}
+	c.lock.RUnlock()
+	return ret
+}
 
-		ret = append(ret, toAdd)
+//GetScrapeStatuses returns the scrape status of every environment with a UUID,
+// by UUID
+func (c *Cache) GetScrapeStatuses() map[string]CacheScrapeStatus {
+	c.lock.RLock()
+	ret := make(map[string]CacheScrapeStatus, len(c.data))
+	for _, env := range c.data {
+		if env.UUID != "" {
+			ret[env.UUID] = env.Scrape
+		}
 	}
 	c.lock.RUnlock()
 	return ret
 }
+
+//GetEnvironment returns a copy of the environment with the given UUID. The
+// returned bool is false if there is no such environment.
+func (c *Cache) GetEnvironment(uuid string) (CacheEnvironment, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	idx := c.findEnvironmentIdx(cacheEnvironmentQuery{UUID: uuid})
+	if idx < 0 {
+		return CacheEnvironment{}, false
+	}
+
+	return c.data[idx].Copy(), true
+}
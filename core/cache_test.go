@@ -0,0 +1,91 @@
+package core
+
+import "testing"
+
+func TestCacheReplacesUnreachableEnvironment(t *testing.T) {
+	cache := NewCache()
+	listener := make(chan string, 10)
+	cache.AddListener(listener)
+
+	cache.UpdateEnvironment(CacheEnvironment{
+		URL:    "https://10.0.0.6:25555",
+		Scrape: CacheScrapeStatus{Status: DirectorStatusUnreachable, LastError: "connection refused"},
+	})
+	cache.UpdateEnvironment(CacheEnvironment{
+		URL:    "https://10.0.0.6:25555",
+		Scrape: CacheScrapeStatus{Status: DirectorStatusUnreachable, LastError: "i/o timeout"},
+	})
+	envs := cache.GetEnvironments()
+	if len(envs) != 1 || envs[0].Scrape.LastError != "i/o timeout" {
+		t.Fatalf("Expected one unreachable environment, got %+v", envs)
+	}
+	if len(listener) != 0 {
+		t.Errorf("Expected listeners not to be told of environments without a UUID")
+	}
+
+	cache.UpdateEnvironment(CacheEnvironment{
+		UUID:   "01234567-89ab-cdef-0123-456789abcdef",
+		URL:    "https://10.0.0.6:25555",
+		Scrape: CacheScrapeStatus{Status: DirectorStatusOK},
+	})
+	envs = cache.GetEnvironments()
+	if len(envs) != 1 || envs[0].Scrape.Status != DirectorStatusOK {
+		t.Fatalf("Expected the unreachable environment to be replaced, got %+v", envs)
+	}
+	if uuid := <-listener; uuid != envs[0].UUID {
+		t.Errorf("Expected listeners to be told of %s, got %s", envs[0].UUID, uuid)
+	}
+}
+
+func TestCacheInstancesHealth(t *testing.T) {
+	instances := CacheInstances{
+		{InstanceGroup: "router", VMCID: "vm-1", ProcessState: "running"},
+		{InstanceGroup: "diego-cell", VMCID: "vm-2", ProcessState: "failing"},
+		{InstanceGroup: "diego-cell", VMCID: "vm-3", ProcessState: "unresponsive agent"},
+		{InstanceGroup: "smoke-tests"},
+		{InstanceGroup: "stopped", ProcessState: "stopped"},
+	}
+
+	healthy, unhealthy := instances.Health()
+	if healthy != 1 || unhealthy != 2 {
+		t.Errorf("Expected 1 healthy and 2 unhealthy instances, got %d and %d", healthy, unhealthy)
+	}
+
+	healthy, unhealthy = CacheInstances(nil).Health()
+	if healthy != 0 || unhealthy != 0 {
+		t.Errorf("Expected no instances to be counted, got %d and %d", healthy, unhealthy)
+	}
+}
+
+func TestCacheKeepsInstancesAcrossUpdates(t *testing.T) {
+	cache := NewCache()
+	cache.UpdateEnvironment(CacheEnvironment{
+		UUID:        "uuid",
+		Deployments: CacheDeployments{{Name: "cf"}, {Name: "redis"}},
+	})
+
+	instances := CacheInstances{{InstanceGroup: "router", VMCID: "vm-1", ProcessState: "running"}}
+	if !cache.UpdateInstances("uuid", "cf", instances) {
+		t.Fatalf("Expected the deployment to be found")
+	}
+	if cache.UpdateInstances("uuid", "concourse", instances) {
+		t.Errorf("Expected an unknown deployment not to be found")
+	}
+
+	//A scrape which started before the instances were updated pushes none
+	cache.UpdateEnvironment(CacheEnvironment{
+		UUID:        "uuid",
+		Deployments: CacheDeployments{{Name: "cf"}, {Name: "concourse"}},
+	})
+
+	env, _ := cache.GetEnvironment("uuid")
+	if len(env.Deployments) != 2 {
+		t.Fatalf("Expected two deployments, got %+v", env.Deployments)
+	}
+	if len(env.Deployments[0].Instances) != 1 || env.Deployments[0].Instances[0].VMCID != "vm-1" {
+		t.Errorf("Expected the instances of cf to be kept, got %+v", env.Deployments[0].Instances)
+	}
+	if env.Deployments[1].Instances != nil {
+		t.Errorf("Expected concourse to have no instances, got %+v", env.Deployments[1].Instances)
+	}
+}
@@ -0,0 +1,288 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/starkandwayne/signalfire/config"
+	"github.com/starkandwayne/signalfire/log"
+	"gopkg.in/yaml.v2"
+)
+
+//Statuses that a release version can have relative to the release catalog
+const (
+	//CatalogStatusCurrent means the version is the latest in the catalog, or
+	// newer
+	CatalogStatusCurrent = "current"
+	//CatalogStatusOutdated means the catalog has a newer version
+	CatalogStatusOutdated = "outdated"
+	//CatalogStatusUnknown means the catalog has no version of the release, or
+	// the version scheme of the release cannot order the version
+	CatalogStatusUnknown = "unknown"
+)
+
+//CatalogSource looks up the latest available version of releases
+type CatalogSource interface {
+	//LatestVersions returns the latest version of each release that the source
+	// knows of, keyed by release name
+	LatestVersions() (map[string]string, error)
+	//Name describes the source, such as the file or URL it reads from
+	Name() string
+}
+
+//NewCatalogSource returns the CatalogSource described by the given
+// configuration, or nil if the catalog is disabled
+func NewCatalogSource(conf config.Catalog, schemes *VersionSchemes) (CatalogSource, error) {
+	switch conf.Type {
+	case "":
+		return nil, nil
+	case config.CatalogTypeFile:
+		if conf.Path == "" {
+			return nil, fmt.Errorf("Type `%s' requires a `path'", conf.Type)
+		}
+		return &FileCatalogSource{Path: conf.Path}, nil
+	case config.CatalogTypeHTTP:
+		if len(conf.Releases) == 0 {
+			return nil, fmt.Errorf("Type `%s' requires a non-empty `releases'", conf.Type)
+		}
+		return &HTTPCatalogSource{
+			URL:      conf.URL,
+			Releases: conf.Releases,
+			Schemes:  schemes,
+			Client:   &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown catalog type `%s'", conf.Type)
+}
+
+//FileCatalogSource reads a YAML or JSON file with a `releases' key mapping
+// release names to their latest versions
+type FileCatalogSource struct {
+	Path string
+}
+
+func (s *FileCatalogSource) Name() string {
+	return s.Path
+}
+
+func (s *FileCatalogSource) LatestVersions() (map[string]string, error) {
+	contents, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	//JSON is valid YAML, so this handles both
+	parsed := struct {
+		Releases map[string]string `yaml:"releases"`
+	}{}
+	err = yaml.Unmarshal(contents, &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse catalog file `%s': %s", s.Path, err)
+	}
+
+	if parsed.Releases == nil {
+		parsed.Releases = map[string]string{}
+	}
+	return parsed.Releases, nil
+}
+
+//HTTPCatalogSource reads release listings in the form served by bosh.io, where
+// the versions of each release are a JSON array of objects with a `version'
+// key
+type HTTPCatalogSource struct {
+	URL string
+	//Releases maps release names to the path of their listing under URL
+	Releases map[string]string
+	//Schemes picks the newest version from each listing
+	Schemes *VersionSchemes
+	Client  *http.Client
+}
+
+func (s *HTTPCatalogSource) Name() string {
+	return s.URL
+}
+
+func (s *HTTPCatalogSource) LatestVersions() (map[string]string, error) {
+	ret := map[string]string{}
+	for release, path := range s.Releases {
+		versions, err := s.fetch(path)
+		if err != nil {
+			return nil, fmt.Errorf("Could not fetch versions of release `%s': %s", release, err)
+		}
+
+		scheme := s.Schemes.For(release)
+		for _, version := range versions {
+			if !scheme.Parses(version) {
+				continue
+			}
+			if latest, found := ret[release]; !found || scheme.Compare(latest, version) < 0 {
+				ret[release] = version
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+func (s *HTTPCatalogSource) fetch(path string) ([]string, error) {
+	u := strings.TrimSuffix(s.URL, "/") + "/" + strings.TrimPrefix(path, "/")
+	resp, err := s.Client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Got status code %d from `%s'", resp.StatusCode, u)
+	}
+
+	listing := []struct {
+		Version string `json:"version"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&listing)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode listing from `%s': %s", u, err)
+	}
+
+	ret := make([]string, 0, len(listing))
+	for _, entry := range listing {
+		if entry.Version != "" {
+			ret = append(ret, entry.Version)
+		}
+	}
+
+	return ret, nil
+}
+
+//Catalog holds the latest available version of each release, as last read
+// from its source
+type Catalog struct {
+	source      CatalogSource
+	latest      map[string]string
+	refreshedAt time.Time
+	lastErr     error
+	lock        sync.RWMutex
+	logger      *log.Logger
+}
+
+func NewCatalog(source CatalogSource, logger *log.Logger) *Catalog {
+	return &Catalog{
+		source: source,
+		latest: map[string]string{},
+		logger: logger,
+	}
+}
+
+//Refresh reads the latest versions from the source. On failure, the versions
+// from the last successful refresh are kept.
+func (c *Catalog) Refresh() error {
+	latest, err := c.source.LatestVersions()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lastErr = err
+	if err != nil {
+		return err
+	}
+
+	c.latest = latest
+	c.refreshedAt = time.Now()
+	return nil
+}
+
+//Start refreshes the catalog now and then on every interval
+func (c *Catalog) Start(interval time.Duration) {
+	go func() {
+		c.refreshAndLog()
+		for range time.Tick(interval) {
+			c.refreshAndLog()
+		}
+	}()
+}
+
+func (c *Catalog) refreshAndLog() {
+	err := c.Refresh()
+	if err != nil {
+		c.logger.Error("Could not refresh release catalog from `%s': %s", c.source.Name(), err)
+		return
+	}
+
+	c.logger.Debug("Refreshed release catalog from `%s'", c.source.Name())
+}
+
+//CatalogState is a snapshot of the catalog
+type CatalogState struct {
+	Source string
+	//Latest maps release names to their latest version
+	Latest      map[string]string
+	RefreshedAt time.Time
+	//LastError is the error of the last refresh, if it failed
+	LastError error
+}
+
+func (c *Catalog) GetState() CatalogState {
+	c.lock.RLock()
+	ret := CatalogState{
+		Source:      c.source.Name(),
+		Latest:      copyStringMap(c.latest),
+		RefreshedAt: c.refreshedAt,
+		LastError:   c.lastErr,
+	}
+	c.lock.RUnlock()
+	return ret
+}
+
+//Latest returns the latest version of the named release. It is safe to call on
+// a nil Catalog, which knows of no releases.
+func (c *Catalog) Latest(release string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	c.lock.RLock()
+	ret, found := c.latest[release]
+	c.lock.RUnlock()
+	return ret, found
+}
+
+//status returns the catalog status of the given version of the release, which
+// is empty if there is no catalog
+func (c *Catalog) status(release *CollationRelease, version string) string {
+	if c == nil {
+		return ""
+	}
+
+	latest, found := c.Latest(release.Name)
+	if !found || !release.Comparable(version) {
+		return CatalogStatusUnknown
+	}
+
+	if release.CompareVersions(version, latest) < 0 {
+		return CatalogStatusOutdated
+	}
+	return CatalogStatusCurrent
+}
+
+//SetCatalog sets the release catalog that release versions are marked against
+func (c *Collator) SetCatalog(catalog *Catalog) {
+	c.lock.Lock()
+	c.catalog = catalog
+	c.lock.Unlock()
+}
+
+//markCatalogStatuses sets the CatalogStatus of every release version of the
+// group. It must be called with the lock held, and only on copies of groups,
+// as the catalog may change without the collator knowing.
+func (c *Collator) markCatalogStatuses(group *CollationDeploymentGroup) {
+	for i := range group.Releases {
+		release := &group.Releases[i]
+		for j := range release.Versions {
+			release.Versions[j].CatalogStatus = c.catalog.status(release, release.Versions[j].Version)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/starkandwayne/signalfire/config"
+)
+
+func TestFileCatalogSourceReadsYAMLAndJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatalf("Could not make temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"catalog.yml":  "releases:\n  bosh: 270.9\n  uaa: \"74.0.0\"\n",
+		"catalog.json": `{"releases": {"bosh": "270.9", "uaa": "74.0.0"}}`,
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		err = ioutil.WriteFile(path, []byte(contents), 0644)
+		if err != nil {
+			t.Fatalf("Could not write `%s': %s", path, err)
+		}
+
+		latest, err := (&FileCatalogSource{Path: path}).LatestVersions()
+		if err != nil {
+			t.Fatalf("%s: LatestVersions: %s", name, err)
+		}
+		if latest["bosh"] != "270.9" || latest["uaa"] != "74.0.0" {
+			t.Errorf("%s: got %v", name, latest)
+		}
+	}
+}
+
+func TestHTTPCatalogSourcePicksNewestVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/releases/github.com/cloudfoundry/bosh":
+			w.Write([]byte(`[{"name": "github.com/cloudfoundry/bosh", "version": "270.2"},
+				{"name": "github.com/cloudfoundry/bosh", "version": "270.10"},
+				{"name": "github.com/cloudfoundry/bosh", "version": "270.9"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	source, err := NewCatalogSource(config.Catalog{
+		Type:     config.CatalogTypeHTTP,
+		URL:      server.URL + "/api/v1/releases/",
+		Releases: map[string]string{"bosh": "github.com/cloudfoundry/bosh"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewCatalogSource: %s", err)
+	}
+
+	latest, err := source.LatestVersions()
+	if err != nil {
+		t.Fatalf("LatestVersions: %s", err)
+	}
+	if latest["bosh"] != "270.10" {
+		t.Errorf("got latest bosh version `%s', want `270.10'", latest["bosh"])
+	}
+
+	source.(*HTTPCatalogSource).Releases["uaa"] = "github.com/cloudfoundry/uaa-release"
+	_, err = source.LatestVersions()
+	if err == nil {
+		t.Errorf("expected an error for a release without a listing")
+	}
+}
+
+type staticCatalogSource map[string]string
+
+func (s staticCatalogSource) Name() string { return "static" }
+
+func (s staticCatalogSource) LatestVersions() (map[string]string, error) {
+	return s, nil
+}
+
+func TestCollatorMarksCatalogStatuses(t *testing.T) {
+	catalog := NewCatalog(staticCatalogSource{"bosh": "270.9"}, nil)
+	err := catalog.Refresh()
+	if err != nil {
+		t.Fatalf("Refresh: %s", err)
+	}
+
+	rules, _ := NewCollationRules(config.DefaultCollationRules)
+	c := newTestCollator(rules)
+	c.SetCatalog(catalog)
+	c.collate([]CacheEnvironment{{
+		Name: "director",
+		UUID: "uuid",
+		Deployments: CacheDeployments{
+			{Name: "dev-bosh", Releases: CacheReleases{{Name: "bosh", Version: "270.2"}}},
+			{Name: "prod-bosh", Releases: CacheReleases{{Name: "bosh", Version: "270.9"}}},
+			{Name: "stg-bosh", Releases: CacheReleases{{Name: "uaa", Version: "74.0.0"}}},
+		},
+	}})
+
+	want := map[string]string{
+		"bosh/270.2": CatalogStatusOutdated,
+		"bosh/270.9": CatalogStatusCurrent,
+		"uaa/74.0.0": CatalogStatusUnknown,
+	}
+	got := map[string]string{}
+	for _, group := range c.GetDeploymentGroups() {
+		for _, release := range group.Releases {
+			for _, version := range release.Versions {
+				got[release.Name+"/"+version.Version] = version.CatalogStatus
+			}
+		}
+	}
+
+	for key, status := range want {
+		if got[key] != status {
+			t.Errorf("%s: got status `%s', want `%s'", key, got[key], status)
+		}
+	}
+}
+
+func TestCollatorWithoutCatalogLeavesStatusesEmpty(t *testing.T) {
+	rules, _ := NewCollationRules(config.DefaultCollationRules)
+	c := newTestCollator(rules)
+	c.collate([]CacheEnvironment{{
+		Name:        "director",
+		UUID:        "uuid",
+		Deployments: CacheDeployments{{Name: "dev-bosh", Releases: CacheReleases{{Name: "bosh", Version: "270.2"}}}},
+	}})
+
+	for _, group := range c.GetDeploymentGroups() {
+		for _, release := range group.Releases {
+			for _, version := range release.Versions {
+				if version.CatalogStatus != "" {
+					t.Errorf("%s/%s: got status `%s', want none", release.Name, version.Version, version.CatalogStatus)
+				}
+			}
+		}
+	}
+}
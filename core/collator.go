@@ -2,21 +2,75 @@ package core
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/starkandwayne/signalfire/config"
 	"github.com/starkandwayne/signalfire/log"
 )
 
+//DefaultCollationDimension is the tag dimension that rules sort deployments
+// into unless they are configured with a different one
+const DefaultCollationDimension = "group"
+
+//CollationMode decides how many groups a deployment may be sorted into within
+// a single dimension
+type CollationMode uint
+
+const (
+	//CollationModeFirstMatch puts a deployment into the group given by the
+	// first matching rule of each dimension
+	CollationModeFirstMatch CollationMode = iota
+	//CollationModeTags tags a deployment with the group given by every matching
+	// rule, so a deployment may be in many groups of the same dimension
+	CollationModeTags
+)
+
+//ParseCollationMode returns the CollationMode with the given configuration name
+func ParseCollationMode(mode string) (ret CollationMode, err error) {
+	switch mode {
+	case config.CollationModeFirstMatch:
+		ret = CollationModeFirstMatch
+	case config.CollationModeTags:
+		ret = CollationModeTags
+	default:
+		err = fmt.Errorf("Unknown collation mode `%s'", mode)
+	}
+
+	return
+}
+
 type Collator struct {
 	groups      []CollationDeploymentGroup
-	idsToGroups map[string]string
+	idsToGroups map[string][]collationGroupKey
 	rules       []CollationRule
+	pipelines   []Pipeline
+	ungrouped   map[string][]CollationDeployment
+	directors   map[string]map[string]CollationDeploymentInput
+	mode        CollationMode
+	schemes     *VersionSchemes
+	catalog     *Catalog
+	advisories  *AdvisoryFeed
+	policies    []Policy
+	violations  []PolicyViolation
 	lock        sync.RWMutex
 	logger      *log.Logger
 }
 
+type collationGroupKey struct {
+	Dimension string
+	Name      string
+}
+
 func NewCollator(logger *log.Logger) *Collator {
-	return &Collator{idsToGroups: make(map[string]string), logger: logger}
+	return &Collator{
+		idsToGroups: make(map[string][]collationGroupKey),
+		ungrouped:   make(map[string][]CollationDeployment),
+		directors:   make(map[string]map[string]CollationDeploymentInput),
+		logger:      logger,
+	}
 }
 
 type CollationDeploymentInput struct {
@@ -24,6 +78,16 @@ type CollationDeploymentInput struct {
 	DirectorName   string
 	DeploymentName string
 	Releases       CacheReleases
+	Stemcells      CacheStemcells
+	//ManifestTags are the top-level tags of the deployment manifest
+	ManifestTags map[string]string
+	//HealthyInstances and UnhealthyInstances count the instances of the
+	// deployment by whether their processes are running
+	HealthyInstances   int
+	UnhealthyInstances int
+	//LastDeployedAt is zero if no recent successful deploy is known
+	LastDeployedAt   time.Time
+	LastDeployFailed bool
 }
 
 func (c *CollationDeploymentInput) calcID() string {
@@ -31,11 +95,17 @@ func (c *CollationDeploymentInput) calcID() string {
 }
 
 func (c *Collator) WatchAsync(cache *Cache) {
-	listenChan := make(chan bool)
+	listenChan := make(chan string)
 	cache.AddListener(listenChan)
 	go func() {
-		for range listenChan {
-			c.collate(cache.GetEnvironments())
+		for uuid := range listenChan {
+			env, found := cache.GetEnvironment(uuid)
+			if !found {
+				//The environment is gone, so collating it as empty removes all of
+				// its deployments
+				env = CacheEnvironment{UUID: uuid}
+			}
+			c.collateEnvironment(env)
 		}
 	}()
 }
@@ -46,12 +116,131 @@ func (c *Collator) AddRule(rule CollationRule) {
 	c.lock.Unlock()
 }
 
+func (c *Collator) SetMode(mode CollationMode) {
+	c.lock.Lock()
+	c.mode = mode
+	c.lock.Unlock()
+}
+
+//SetVersionSchemes chooses how the versions of each release are ordered.
+// Releases which are already collated are re-sorted.
+func (c *Collator) SetVersionSchemes(schemes *VersionSchemes) {
+	c.lock.Lock()
+	c.schemes = schemes
+	for i := range c.groups {
+		for j := range c.groups[i].Releases {
+			release := &c.groups[i].Releases[j]
+			release.setScheme(schemes.For(release.Name))
+		}
+		for j := range c.groups[i].Stemcells {
+			c.groups[i].Stemcells[j].setScheme(schemes.ForStemcells())
+		}
+	}
+	c.evaluatePolicies()
+	c.lock.Unlock()
+}
+
+//GetVersionSchemes returns the version schemes that releases are ordered by
+func (c *Collator) GetVersionSchemes() *VersionSchemes {
+	c.lock.RLock()
+	ret := c.schemes
+	c.lock.RUnlock()
+	return ret
+}
+
+//GetDeploymentGroups returns the groups of the default dimension
 func (c *Collator) GetDeploymentGroups() []CollationDeploymentGroup {
+	ret, _ := c.GetDeploymentGroupsByDimension(DefaultCollationDimension)
+	return ret
+}
+
+//GetDeploymentGroupsByDimension returns the groups of the given dimension. The
+// returned bool is false if no rule sorts deployments into that dimension.
+func (c *Collator) GetDeploymentGroupsByDimension(dimension string) ([]CollationDeploymentGroup, bool) {
 	c.lock.RLock()
-	ret := make([]CollationDeploymentGroup, 0, len(c.groups))
+	ret := []CollationDeploymentGroup{}
+	advisories := c.advisories.GetAdvisories()
 	for _, deploymentGroup := range c.groups {
-		ret = append(ret, deploymentGroup)
+		if deploymentGroup.Dimension == dimension {
+			toAdd := deploymentGroup.copy()
+			c.markCatalogStatuses(&toAdd)
+			c.markAdvisories(&toAdd, advisories)
+			toAdd.PolicyViolations = c.countViolations(&deploymentGroup)
+			ret = append(ret, toAdd)
+		}
+	}
+	found := c.hasDimension(dimension)
+	c.lock.RUnlock()
+	return ret, found
+}
+
+//GetStemcells returns every stemcell in use across all directors, with the
+// IDs of the deployments using each version, sorted by operating system
+func (c *Collator) GetStemcells() []CollationStemcell {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	byOS := map[string]*CollationStemcell{}
+	for _, deployments := range c.directors {
+		for id, deployment := range deployments {
+			for _, stemcell := range deployment.Stemcells {
+				if byOS[stemcell.OS] == nil {
+					byOS[stemcell.OS] = &CollationStemcell{
+						OS:     stemcell.OS,
+						scheme: c.schemes.ForStemcells(),
+					}
+				}
+				byOS[stemcell.OS].addDeploymentVersion(id, stemcell.Version)
+			}
+		}
+	}
+
+	ret := make([]CollationStemcell, 0, len(byOS))
+	for _, stemcell := range byOS {
+		for i := range stemcell.Versions {
+			sort.Strings(stemcell.Versions[i].Deployments)
+		}
+		ret = append(ret, *stemcell)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].OS < ret[j].OS })
+	return ret
+}
+
+//GetDeploymentGroup returns the named group of the given dimension. The
+// returned bool is false if there is no such group.
+func (c *Collator) GetDeploymentGroup(dimension, name string) (CollationDeploymentGroup, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	groupIdx := c.findGroupIdx(collationGroupKey{Dimension: dimension, Name: name})
+	if groupIdx < 0 {
+		return CollationDeploymentGroup{}, false
 	}
+
+	return c.groups[groupIdx].copy(), true
+}
+
+//GetDimensions returns the names of all dimensions that the configured rules
+// sort deployments into, in sorted order
+func (c *Collator) GetDimensions() []string {
+	c.lock.RLock()
+	ret := c.dimensions()
+	c.lock.RUnlock()
+	return ret
+}
+
+//GetUngroupedDeployments returns the deployments which did not match any
+// collation rule of the default dimension
+func (c *Collator) GetUngroupedDeployments() []CollationDeployment {
+	return c.GetUngroupedDeploymentsByDimension(DefaultCollationDimension)
+}
+
+//GetUngroupedDeploymentsByDimension returns the deployments which did not
+// match any collation rule of the given dimension
+func (c *Collator) GetUngroupedDeploymentsByDimension(dimension string) []CollationDeployment {
+	c.lock.RLock()
+	ret := make([]CollationDeployment, len(c.ungrouped[dimension]))
+	copy(ret, c.ungrouped[dimension])
 	c.lock.RUnlock()
 	return ret
 }
@@ -59,24 +248,75 @@ func (c *Collator) GetDeploymentGroups() []CollationDeploymentGroup {
 func (c *Collator) collate(envs []CacheEnvironment) {
 	c.lock.Lock()
 	c.groups = []CollationDeploymentGroup{}
-	c.idsToGroups = map[string]string{}
+	c.idsToGroups = map[string][]collationGroupKey{}
+	c.ungrouped = map[string][]CollationDeployment{}
+	c.directors = map[string]map[string]CollationDeploymentInput{}
 	for _, env := range envs {
-		deployments := c.flattenEnvDeployments(env)
-		for _, deployment := range deployments {
-			c.addDeployment(deployment)
-		}
+		c.applyEnvironment(env)
 	}
+	c.evaluatePolicies()
+	c.lock.Unlock()
+}
+
+//collateEnvironment updates the collation with the deployments of a single
+// environment, leaving the deployments of every other director in place
+func (c *Collator) collateEnvironment(env CacheEnvironment) {
+	c.lock.Lock()
+	c.applyEnvironment(env)
+	c.evaluatePolicies()
 	c.lock.Unlock()
 }
 
+//applyEnvironment must be called with the lock held. c.directors remembers the
+// input of every deployment that was last applied, keyed by director UUID and
+// deployment ID, so only deployments which were added, removed, or changed
+// since then are touched.
+func (c *Collator) applyEnvironment(env CacheEnvironment) {
+	previous := c.directors[env.UUID]
+	current := map[string]CollationDeploymentInput{}
+	for _, deployment := range c.flattenEnvDeployments(env) {
+		current[deployment.calcID()] = deployment
+	}
+
+	for id := range previous {
+		if _, stillPresent := current[id]; !stillPresent {
+			c.removeDeployment(id)
+		}
+	}
+
+	for id, deployment := range current {
+		if old, found := previous[id]; found && reflect.DeepEqual(old, deployment) {
+			continue
+		}
+		c.addDeployment(deployment)
+	}
+
+	if len(current) == 0 {
+		delete(c.directors, env.UUID)
+		return
+	}
+	c.directors[env.UUID] = current
+}
+
 func (*Collator) flattenEnvDeployments(env CacheEnvironment) []CollationDeploymentInput {
 	ret := []CollationDeploymentInput{}
 	for _, deployment := range env.Deployments {
+		healthy, unhealthy := deployment.Instances.Health()
+		var lastDeployedAt time.Time
+		if deployment.Tasks.LastDeploy != nil {
+			lastDeployedAt = deployment.Tasks.LastDeploy.FinishedAt
+		}
 		toAppend := CollationDeploymentInput{
-			DirectorName:   env.Name,
-			DirectorUUID:   env.UUID,
-			DeploymentName: deployment.Name,
-			Releases:       deployment.Releases.Copy(),
+			DirectorName:       env.Name,
+			DirectorUUID:       env.UUID,
+			DeploymentName:     deployment.Name,
+			Releases:           deployment.Releases.Copy(),
+			Stemcells:          deployment.Stemcells.Copy(),
+			ManifestTags:       copyStringMap(deployment.Tags),
+			HealthyInstances:   healthy,
+			UnhealthyInstances: unhealthy,
+			LastDeployedAt:     lastDeployedAt,
+			LastDeployFailed:   deployment.Tasks.LastDeployFailed,
 		}
 		ret = append(ret, toAppend)
 	}
@@ -84,69 +324,136 @@ func (*Collator) flattenEnvDeployments(env CacheEnvironment) []CollationDeployme
 	return ret
 }
 
-func (c *Collator) calcDeploymentGroupName(deployment CollationDeploymentInput) (string, bool) {
-	var group string
-	//Sort it into a deployment group
+//dimensions must be called with the lock held
+func (c *Collator) dimensions() []string {
+	seen := map[string]bool{DefaultCollationDimension: true}
+	ret := []string{DefaultCollationDimension}
 	for _, rule := range c.rules {
-		group = rule.DeploymentGroup(deployment)
-		if group != "" {
-			break
+		dimension := collationRuleDimension(rule)
+		if !seen[dimension] {
+			seen[dimension] = true
+			ret = append(ret, dimension)
 		}
 	}
 
-	return group, group != ""
+	sort.Strings(ret)
+	return ret
 }
 
-func (c *Collator) addDeployment(deployment CollationDeploymentInput) {
-	group, gotGroup := c.calcDeploymentGroupName(deployment)
-	if !gotGroup {
-		//TODO: Do something with deployments which could not be sorted
-		c.logger.Info("Dropping deployment `%s' because no group match", deployment.DeploymentName)
-		return
+//hasDimension must be called with the lock held
+func (c *Collator) hasDimension(dimension string) bool {
+	for _, d := range c.dimensions() {
+		if d == dimension {
+			return true
+		}
 	}
 
+	return false
+}
+
+//calcDeploymentTags returns the group names that the deployment belongs to,
+// keyed by dimension
+func (c *Collator) calcDeploymentTags(deployment CollationDeploymentInput) map[string][]string {
+	ret := map[string][]string{}
+	//Sort it into deployment groups
+	for i, rule := range c.rules {
+		dimension := collationRuleDimension(rule)
+		if c.mode == CollationModeFirstMatch && len(ret[dimension]) > 0 {
+			continue
+		}
+
+		group, err := collationRuleGroup(rule, deployment)
+		if err != nil {
+			c.logger.Error("Collation rule at index %d could not sort deployment `%s': %s",
+				i,
+				deployment.DeploymentName,
+				err)
+		}
+		if group == "" || containsString(ret[dimension], group) {
+			continue
+		}
+
+		ret[dimension] = append(ret[dimension], group)
+	}
+
+	return ret
+}
+
+func (c *Collator) addDeployment(deployment CollationDeploymentInput) {
 	//Remove a possibly stale entry, then add this one
 	deploymentID := deployment.calcID()
-	//The removal may not be needed now, as we're just wiping the whole cache
-	// every time we do a collate?
 	c.removeDeployment(deploymentID)
-	c.idsToGroups[deploymentID] = group
-	groupIdx := c.findGroupIdxByName(group)
-	if groupIdx < 0 {
-		//If group doesn't exist... make it!
-		c.groups = append(c.groups, *newCollationDeploymentGroup(group))
-		groupIdx = len(c.groups) - 1
+
+	tags := c.calcDeploymentTags(deployment)
+	dep := newCollationDeployment(deployment)
+	dep.Tags = tags
+	for _, dimension := range c.dimensions() {
+		if len(tags[dimension]) == 0 {
+			c.logger.Info("Deployment `%s' matched no group in dimension `%s'; marking it as ungrouped",
+				deployment.DeploymentName,
+				dimension)
+			c.ungrouped[dimension] = append(c.ungrouped[dimension], dep)
+			continue
+		}
+
+		for _, group := range tags[dimension] {
+			key := collationGroupKey{Dimension: dimension, Name: group}
+			c.idsToGroups[deploymentID] = append(c.idsToGroups[deploymentID], key)
+			groupIdx := c.findGroupIdx(key)
+			if groupIdx < 0 {
+				//If group doesn't exist... make it!
+				c.groups = append(c.groups, *newCollationDeploymentGroup(dimension, group))
+				groupIdx = len(c.groups) - 1
+			}
+			c.groups[groupIdx].addDeployment(dep, deployment, c.schemes)
+			c.logger.Debug("Inserted deployment with name `%s' into group `%s' of dimension `%s'\n",
+				deployment.DeploymentName,
+				group,
+				dimension)
+		}
 	}
-	c.groups[groupIdx].addDeployment(deployment)
-	c.logger.Debug("Inserted deployment with name `%s' into group `%s'\n",
-		deployment.DeploymentName,
-		group)
 }
 
 func (c *Collator) removeDeployment(deploymentID string) {
-	groupName, found := c.idsToGroups[deploymentID]
+	c.removeUngroupedDeployment(deploymentID)
+	groupKeys, found := c.idsToGroups[deploymentID]
 	if !found {
 		return
 	}
 
-	groupIdx := c.findGroupIdxByName(groupName)
-	if groupIdx < 0 {
-		c.logger.Fatal("Group id mapping found but no group present. This is a bug.")
-	}
+	for _, key := range groupKeys {
+		groupIdx := c.findGroupIdx(key)
+		if groupIdx < 0 {
+			c.logger.Fatal("Group id mapping found but no group present. This is a bug.")
+		}
 
-	groupIsEmpty := c.groups[groupIdx].removeDeploymentByID(deploymentID)
-	if groupIsEmpty {
-		lastIdx := len(c.groups) - 1
-		c.groups[groupIdx], c.groups[lastIdx] = c.groups[lastIdx], c.groups[groupIdx]
-		c.groups = c.groups[:lastIdx]
+		groupIsEmpty := c.groups[groupIdx].removeDeploymentByID(deploymentID)
+		if groupIsEmpty {
+			lastIdx := len(c.groups) - 1
+			c.groups[groupIdx], c.groups[lastIdx] = c.groups[lastIdx], c.groups[groupIdx]
+			c.groups = c.groups[:lastIdx]
+		}
 	}
 	delete(c.idsToGroups, deploymentID)
 }
 
-func (c *Collator) findGroupIdxByName(name string) int {
+func (c *Collator) removeUngroupedDeployment(deploymentID string) {
+	for dimension, ungrouped := range c.ungrouped {
+		for i := range ungrouped {
+			if ungrouped[i].ID == deploymentID {
+				lastIdx := len(ungrouped) - 1
+				ungrouped[i], ungrouped[lastIdx] = ungrouped[lastIdx], ungrouped[i]
+				c.ungrouped[dimension] = ungrouped[:lastIdx]
+				break
+			}
+		}
+	}
+}
+
+func (c *Collator) findGroupIdx(key collationGroupKey) int {
 	ret := -1
 	for i := range c.groups {
-		if c.groups[i].Name == name {
+		if c.groups[i].Dimension == key.Dimension && c.groups[i].Name == key.Name {
 			ret = i
 			break
 		}
@@ -154,3 +461,13 @@ func (c *Collator) findGroupIdxByName(name string) int {
 
 	return ret
 }
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,208 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"testing"
+
+	"github.com/starkandwayne/signalfire/config"
+	"github.com/starkandwayne/signalfire/log"
+)
+
+const (
+	benchDirectors              = 20
+	benchDeploymentsPerDirector = 75
+	benchReleasesPerDeployment  = 10
+)
+
+//newTestCollator returns a collator with the given rules, which logs nothing
+func newTestCollator(rules []CollationRule) *Collator {
+	c := NewCollator(testLogger())
+	for _, rule := range rules {
+		c.AddRule(rule)
+	}
+
+	return c
+}
+
+func testLogger() *log.Logger {
+	return &log.Logger{Output: ioutil.Discard, Level: log.LevelFatal}
+}
+
+func newBenchCollator(b *testing.B) *Collator {
+	rules, err := NewCollationRules(config.DefaultCollationRules)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return newTestCollator(rules)
+}
+
+func newBenchEnvironments() []CacheEnvironment {
+	ret := make([]CacheEnvironment, 0, benchDirectors)
+	for i := 0; i < benchDirectors; i++ {
+		env := CacheEnvironment{
+			Name: fmt.Sprintf("director-%d", i),
+			UUID: fmt.Sprintf("uuid-%d", i),
+		}
+		for j := 0; j < benchDeploymentsPerDirector; j++ {
+			dep := CacheDeployment{Name: fmt.Sprintf("env%d-product%d", i, j%25)}
+			for k := 0; k < benchReleasesPerDeployment; k++ {
+				dep.Releases = append(dep.Releases, CacheRelease{
+					Name:    fmt.Sprintf("release-%d", k),
+					Version: fmt.Sprintf("%d.%d.0", k, (i+j)%7),
+				})
+			}
+			env.Deployments = append(env.Deployments, dep)
+		}
+		ret = append(ret, env)
+	}
+
+	return ret
+}
+
+//bumpVersion changes one release version of one deployment of the environment
+func bumpVersion(env CacheEnvironment, n int) CacheEnvironment {
+	env = env.Copy()
+	dep := &env.Deployments[n%len(env.Deployments)]
+	dep.Releases[0].Version = fmt.Sprintf("0.%d.1", n)
+	return env
+}
+
+//BenchmarkCollateFull rebuilds the whole collation when one director changes,
+// as was done before collation became incremental
+func BenchmarkCollateFull(b *testing.B) {
+	c := newBenchCollator(b)
+	envs := newBenchEnvironments()
+	c.collate(envs)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		envs[i%len(envs)] = bumpVersion(envs[i%len(envs)], i)
+		c.collate(envs)
+	}
+}
+
+//BenchmarkCollateIncremental applies only the changed director
+func BenchmarkCollateIncremental(b *testing.B) {
+	c := newBenchCollator(b)
+	envs := newBenchEnvironments()
+	c.collate(envs)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		envs[i%len(envs)] = bumpVersion(envs[i%len(envs)], i)
+		c.collateEnvironment(envs[i%len(envs)])
+	}
+}
+
+//BenchmarkCollateUnchanged applies a director scrape which found no changes
+func BenchmarkCollateUnchanged(b *testing.B) {
+	c := newBenchCollator(b)
+	envs := newBenchEnvironments()
+	c.collate(envs)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.collateEnvironment(envs[i%len(envs)])
+	}
+}
+
+func TestCollateEnvironmentMatchesFullCollate(t *testing.T) {
+	rules, _ := NewCollationRules(config.DefaultCollationRules)
+	envs := newBenchEnvironments()
+	incremental := newTestCollator(rules)
+	incremental.collate(envs)
+	for i := 0; i < 50; i++ {
+		env := bumpVersion(envs[i%len(envs)], i)
+		//Also drop a deployment, so that removal is exercised
+		env.Deployments = env.Deployments[:len(env.Deployments)-1]
+		envs[i%len(envs)] = env
+		incremental.collateEnvironment(env)
+	}
+
+	full := newTestCollator(rules)
+	full.collate(envs)
+
+	got, want := summarizeGroups(incremental), summarizeGroups(full)
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d groups, got %d", len(want), len(got))
+	}
+	for name, summary := range want {
+		if got[name] != summary {
+			t.Errorf("Group `%s' differs\nexpected: %s\ngot:      %s", name, summary, got[name])
+		}
+	}
+}
+
+//summarizeGroups renders each group in a form that does not depend on the
+// order in which deployments were added
+func summarizeGroups(c *Collator) map[string]string {
+	ret := map[string]string{}
+	for _, group := range c.GetDeploymentGroups() {
+		deployments := []string{}
+		for _, dep := range group.Deployments {
+			deployments = append(deployments, dep.ID)
+		}
+		sort.Strings(deployments)
+
+		releases := []string{}
+		for _, release := range group.Releases {
+			for _, version := range release.Versions {
+				ids := append([]string{}, version.Deployments...)
+				sort.Strings(ids)
+				releases = append(releases, fmt.Sprintf("%s@%s:%v", release.Name, version.Version, ids))
+			}
+		}
+		sort.Strings(releases)
+		ret[group.Name] = fmt.Sprintf("%v %v", deployments, releases)
+	}
+
+	return ret
+}
+
+func TestCollateStemcells(t *testing.T) {
+	rules, _ := NewCollationRules(config.DefaultCollationRules)
+	c := newTestCollator(rules)
+
+	xenial := func(version string) CacheStemcells {
+		return CacheStemcells{{Name: "bosh-aws-xen-hvm-ubuntu-xenial-go_agent", OS: "ubuntu-xenial", Version: version}}
+	}
+	env := CacheEnvironment{
+		Name: "director",
+		UUID: "uuid",
+		Deployments: CacheDeployments{
+			{Name: "dev-cf", Stemcells: xenial("621.10")},
+			{Name: "prod-cf", Stemcells: xenial("621.5")},
+			{Name: "prod-bosh", Stemcells: xenial("456.30")},
+		},
+	}
+	c.collateEnvironment(env)
+
+	var cf *CollationDeploymentGroup
+	groups := c.GetDeploymentGroups()
+	for i := range groups {
+		if groups[i].Name == "cf" {
+			cf = &groups[i]
+		}
+	}
+	if cf == nil || len(cf.Stemcells) != 1 {
+		t.Fatalf("Expected group `cf' with one stemcell OS, got %+v", cf)
+	}
+	versions := cf.Stemcells[0].Versions
+	if len(versions) != 2 || versions[0].Version != "621.5" || versions[1].Version != "621.10" {
+		t.Errorf("Expected versions 621.5 then 621.10, got %+v", versions)
+	}
+
+	stemcells := c.GetStemcells()
+	if len(stemcells) != 1 || len(stemcells[0].Versions) != 3 {
+		t.Fatalf("Expected one stemcell OS with three versions, got %+v", stemcells)
+	}
+	if stemcells[0].Versions[0].Version != "456.30" {
+		t.Errorf("Expected oldest version 456.30, got %s", stemcells[0].Versions[0].Version)
+	}
+
+	env.Deployments = env.Deployments[:1]
+	c.collateEnvironment(env)
+	if stemcells = c.GetStemcells(); len(stemcells[0].Versions) != 1 {
+		t.Errorf("Expected only the version of dev-cf to remain, got %+v", stemcells[0].Versions)
+	}
+}
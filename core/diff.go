@@ -0,0 +1,260 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+//Kinds of difference between two YAML documents
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+//YAMLDifference is one difference between two YAML documents. A is the value
+// in the first document and B in the second, either of which is nil if the
+// value was added or removed.
+type YAMLDifference struct {
+	//Path locates the value in the documents, such as
+	// /instance_groups/router/jobs/gorouter. Elements of lists whose entries
+	// all have a `name' are located by name, and others by index.
+	Path string
+	Kind string
+	A    interface{}
+	B    interface{}
+}
+
+//DiffYAML structurally compares two YAML documents, ignoring the top-level keys
+// given. Differences are returned in order of path.
+func DiffYAML(a, b string, ignoreKeys ...string) ([]YAMLDifference, error) {
+	docA, err := parseYAMLDocument(a)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse first document: %s", err)
+	}
+
+	docB, err := parseYAMLDocument(b)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse second document: %s", err)
+	}
+
+	for _, key := range ignoreKeys {
+		if m, isMap := docA.(map[string]interface{}); isMap {
+			delete(m, key)
+		}
+		if m, isMap := docB.(map[string]interface{}); isMap {
+			delete(m, key)
+		}
+	}
+
+	ret := []YAMLDifference{}
+	diffYAMLValues("", docA, docB, &ret)
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Path < ret[j].Path })
+	return ret, nil
+}
+
+func parseYAMLDocument(doc string) (interface{}, error) {
+	var ret interface{}
+	err := yaml.Unmarshal([]byte(doc), &ret)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizeYAML(ret), nil
+}
+
+//normalizeYAML converts the maps decoded by yaml.v2, which are keyed by
+// interface{}, into maps keyed by string, so that they can be encoded as JSON
+func normalizeYAML(v interface{}) interface{} {
+	switch typed := v.(type) {
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(typed))
+		for k, val := range typed {
+			ret[fmt.Sprintf("%v", k)] = normalizeYAML(val)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(typed))
+		for i := range typed {
+			ret[i] = normalizeYAML(typed[i])
+		}
+		return ret
+	}
+
+	return v
+}
+
+func diffYAMLValues(path string, a, b interface{}, out *[]YAMLDifference) {
+	mapA, aIsMap := a.(map[string]interface{})
+	mapB, bIsMap := b.(map[string]interface{})
+	if aIsMap && bIsMap {
+		diffYAMLMaps(path, mapA, mapB, out)
+		return
+	}
+
+	listA, aIsList := a.([]interface{})
+	listB, bIsList := b.([]interface{})
+	if aIsList && bIsList {
+		namedA, aIsNamed := namedEntries(listA)
+		namedB, bIsNamed := namedEntries(listB)
+		if aIsNamed && bIsNamed {
+			diffYAMLMaps(path, namedA, namedB, out)
+			return
+		}
+
+		diffYAMLLists(path, listA, listB, out)
+		return
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*out = append(*out, YAMLDifference{Path: rootPath(path), Kind: DiffChanged, A: a, B: b})
+	}
+}
+
+func diffYAMLMaps(path string, a, b map[string]interface{}, out *[]YAMLDifference) {
+	for key, valA := range a {
+		keyPath := path + "/" + key
+		valB, inB := b[key]
+		if !inB {
+			*out = append(*out, YAMLDifference{Path: keyPath, Kind: DiffRemoved, A: valA})
+			continue
+		}
+		diffYAMLValues(keyPath, valA, valB, out)
+	}
+
+	for key, valB := range b {
+		if _, inA := a[key]; !inA {
+			*out = append(*out, YAMLDifference{Path: path + "/" + key, Kind: DiffAdded, B: valB})
+		}
+	}
+}
+
+func diffYAMLLists(path string, a, b []interface{}, out *[]YAMLDifference) {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		idxPath := fmt.Sprintf("%s/%d", path, i)
+		switch {
+		case i >= len(b):
+			*out = append(*out, YAMLDifference{Path: idxPath, Kind: DiffRemoved, A: a[i]})
+		case i >= len(a):
+			*out = append(*out, YAMLDifference{Path: idxPath, Kind: DiffAdded, B: b[i]})
+		default:
+			diffYAMLValues(idxPath, a[i], b[i], out)
+		}
+	}
+}
+
+//namedEntries keys the entries of the list by their `name', if every entry is
+// a map with a unique string name
+func namedEntries(list []interface{}) (map[string]interface{}, bool) {
+	if len(list) == 0 {
+		return nil, false
+	}
+
+	ret := make(map[string]interface{}, len(list))
+	for _, entry := range list {
+		m, isMap := entry.(map[string]interface{})
+		if !isMap {
+			return nil, false
+		}
+
+		name, isString := m["name"].(string)
+		if !isString || name == "" {
+			return nil, false
+		}
+		if _, duplicate := ret[name]; duplicate {
+			return nil, false
+		}
+		ret[name] = entry
+	}
+
+	return ret, true
+}
+
+func rootPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+
+	return path
+}
+
+//redactedValue replaces values that look like secrets
+const redactedValue = "<redacted>"
+
+//secretKeyWords are parts of keys whose values are likely to be secret
+var secretKeyWords = []string{"password", "secret", "token", "private_key", "ssh_key", "credential", "passphrase"}
+
+//publicKeyWords are parts of keys ending in `key' whose values are not secret
+var publicKeyWords = []string{"public_key", "certificate"}
+
+//RedactYAML returns the YAML document with values that look like secrets
+// replaced. A value looks like a secret if it is under a key containing a word
+// such as "password" or "secret" or ending in "key", however deeply, or if it
+// is a PEM private key.
+func RedactYAML(doc string) (string, error) {
+	parsed, err := parseYAMLDocument(doc)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := yaml.Marshal(redactYAMLValue(false, "", parsed))
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+//redactYAMLValue redacts the leaves of the value which look like secrets. Every
+// leaf under a key which looks secret is redacted, so the structure under a key
+// such as `credentials' can still be compared.
+func redactYAMLValue(underSecretKey bool, key string, v interface{}) interface{} {
+	secret := underSecretKey || looksSecretKey(key)
+	switch typed := v.(type) {
+	case map[string]interface{}:
+		for k, val := range typed {
+			typed[k] = redactYAMLValue(secret, k, val)
+		}
+		return typed
+	case []interface{}:
+		for i := range typed {
+			typed[i] = redactYAMLValue(secret, "", typed[i])
+		}
+		return typed
+	}
+
+	if secret {
+		return redactedValue
+	}
+	if s, isString := v.(string); isString && strings.Contains(s, "PRIVATE KEY-----") {
+		return redactedValue
+	}
+
+	return v
+}
+
+func looksSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range secretKeyWords {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+
+	//Keys such as `api_key' or `db_encryption_key' are secret, but not
+	// `public_key' or the key of a certificate
+	if strings.HasSuffix(key, "key") {
+		for _, word := range publicKeyWords {
+			if strings.Contains(key, word) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"sort"
+	"strings"
+)
+
+//DirectorVersionReport shows the skew of BOSH director versions across all
+// environments
+type DirectorVersionReport struct {
+	//Newest is the newest director version in use
+	Newest   string
+	Versions []DirectorVersion
+}
+
+//DirectorVersion lists the directors running one version of BOSH
+type DirectorVersion struct {
+	Version string
+	//VersionsBehind is the number of distinct director versions in use which
+	// are newer than this one. Versions which compare as equal are not distinct.
+	VersionsBehind int
+	//Directors are the UUIDs of the directors on this version
+	Directors []string
+}
+
+//directorVersionNumber strips the build commit from director versions such as
+// "270.11.1 (00000000)"
+func directorVersionNumber(version string) string {
+	return strings.TrimSpace(strings.SplitN(version, "(", 2)[0])
+}
+
+//NewDirectorVersionReport reports the director versions of the given
+// environments, oldest first. Directors whose version is unknown are left out.
+func NewDirectorVersionReport(envs []CacheEnvironment) DirectorVersionReport {
+	byVersion := map[string][]string{}
+	for _, env := range envs {
+		version := directorVersionNumber(env.Director.Version)
+		if version == "" {
+			continue
+		}
+		byVersion[version] = append(byVersion[version], env.UUID)
+	}
+
+	ret := DirectorVersionReport{Versions: make([]DirectorVersion, 0, len(byVersion))}
+	for version, directors := range byVersion {
+		sort.Strings(directors)
+		ret.Versions = append(ret.Versions, DirectorVersion{
+			Version:   version,
+			Directors: directors,
+		})
+	}
+
+	//Director versions are dotted numbers, which the legacy scheme orders
+	scheme := LegacyVersionScheme{}
+	sort.Slice(ret.Versions, func(i, j int) bool {
+		if diff := scheme.Compare(ret.Versions[i].Version, ret.Versions[j].Version); diff != 0 {
+			return diff < 0
+		}
+		return ret.Versions[i].Version < ret.Versions[j].Version
+	})
+
+	//Versions which the scheme orders as equal, such as 270.11 and 270.11.0,
+	// count as a single step, as in drift reports
+	for i := len(ret.Versions) - 2; i >= 0; i-- {
+		ret.Versions[i].VersionsBehind = ret.Versions[i+1].VersionsBehind
+		if scheme.Compare(ret.Versions[i].Version, ret.Versions[i+1].Version) != 0 {
+			ret.Versions[i].VersionsBehind++
+		}
+	}
+	if len(ret.Versions) > 0 {
+		ret.Newest = ret.Versions[len(ret.Versions)-1].Version
+	}
+
+	return ret
+}
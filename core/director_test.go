@@ -0,0 +1,75 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewDirectorVersionReport(t *testing.T) {
+	tests := []struct {
+		name string
+		//versions of directors, keyed by UUID, where an empty version is that of
+		// a director which has not been reached
+		versions map[string]string
+		newest   string
+		want     []DirectorVersion
+	}{
+		{
+			name:     "no directors",
+			versions: map[string]string{},
+			want:     []DirectorVersion{},
+		},
+		{
+			name:     "single director",
+			versions: map[string]string{"a": "270.11.1 (00000000)"},
+			newest:   "270.11.1",
+			want:     []DirectorVersion{{Version: "270.11.1", Directors: []string{"a"}}},
+		},
+		{
+			name:     "skew",
+			versions: map[string]string{"a": "270.11.1 (00000000)", "b": "270.2.0 (11111111)", "c": "270.11.1 (22222222)", "d": "269.0.0"},
+			newest:   "270.11.1",
+			want: []DirectorVersion{
+				{Version: "269.0.0", VersionsBehind: 2, Directors: []string{"d"}},
+				{Version: "270.2.0", VersionsBehind: 1, Directors: []string{"b"}},
+				{Version: "270.11.1", Directors: []string{"a", "c"}},
+			},
+		},
+		{
+			name:     "equal versions count as one step",
+			versions: map[string]string{"a": "270.11", "b": "270.11.0", "c": "270.12.0", "d": "270.10.0"},
+			newest:   "270.12.0",
+			want: []DirectorVersion{
+				{Version: "270.10.0", VersionsBehind: 2, Directors: []string{"d"}},
+				{Version: "270.11", VersionsBehind: 1, Directors: []string{"a"}},
+				{Version: "270.11.0", VersionsBehind: 1, Directors: []string{"b"}},
+				{Version: "270.12.0", Directors: []string{"c"}},
+			},
+		},
+		{
+			name:     "unreachable directors are left out",
+			versions: map[string]string{"a": "270.11.1 (00000000)", "b": "", "c": ""},
+			newest:   "270.11.1",
+			want:     []DirectorVersion{{Version: "270.11.1", Directors: []string{"a"}}},
+		},
+	}
+
+	for _, test := range tests {
+		envs := []CacheEnvironment{}
+		for uuid, version := range test.versions {
+			env := CacheEnvironment{UUID: uuid, Director: CacheDirector{Version: version}}
+			if version == "" {
+				env.Scrape.Status = DirectorStatusUnreachable
+			}
+			envs = append(envs, env)
+		}
+
+		report := NewDirectorVersionReport(envs)
+		if report.Newest != test.newest {
+			t.Errorf("%s: Expected newest version `%s', got `%s'", test.name, test.newest, report.Newest)
+		}
+		if !reflect.DeepEqual(report.Versions, test.want) {
+			t.Errorf("%s: Versions:\nexpected: %+v\ngot:      %+v", test.name, test.want, report.Versions)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+)
+
+//DriftReport describes how far the deployments of a group are behind the
+// newest version of each release in that group
+type DriftReport struct {
+	Group     string
+	Dimension string
+	Releases  []DriftReportRelease
+	//Score is the total number of versions that deployments are behind, summed
+	// across all releases of the group. A group where every deployment runs
+	// the newest version of every release has a score of zero.
+	Score int
+}
+
+type DriftReportRelease struct {
+	Name string
+	//Newest is the newest version of the release in the group
+	Newest string
+	//Behind lists the deployments which are not on the newest version, with
+	// the deployments furthest behind first
+	Behind []DriftReportDeployment
+}
+
+type DriftReportDeployment struct {
+	ID      string
+	Version string
+	//VersionsBehind is the number of distinct versions in use in the group which
+	// are newer than the version of this deployment. Versions which the version
+	// scheme orders as equal are not distinct.
+	VersionsBehind int
+}
+
+//GetDriftReport reports how far the deployments of the named group are behind
+// the newest version of each release in the group
+func (c *Collator) GetDriftReport(dimension, groupName string) (*DriftReport, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	groupIdx := c.findGroupIdx(collationGroupKey{Dimension: dimension, Name: groupName})
+	if groupIdx < 0 {
+		return nil, fmt.Errorf("No group with name `%s' in dimension `%s'", groupName, dimension)
+	}
+
+	ret := c.groups[groupIdx].Drift()
+	return &ret, nil
+}
+
+//Drift reports how far the deployments of the group are behind the newest
+// version of each release in the group
+func (c CollationDeploymentGroup) Drift() DriftReport {
+	ret := DriftReport{
+		Group:     c.Name,
+		Dimension: c.Dimension,
+		Releases:  make([]DriftReportRelease, 0, len(c.Releases)),
+	}
+
+	for _, release := range c.Releases {
+		toAdd := release.drift()
+		for _, dep := range toAdd.Behind {
+			ret.Score += dep.VersionsBehind
+		}
+		ret.Releases = append(ret.Releases, toAdd)
+	}
+
+	return ret
+}
+
+func (r CollationRelease) drift() DriftReportRelease {
+	ret := DriftReportRelease{
+		Name:   r.Name,
+		Behind: []DriftReportDeployment{},
+	}
+
+	//Incomparable versions cannot be behind or ahead of any other
+	versions := make([]CollationReleaseVersion, 0, len(r.Versions))
+	for _, version := range r.Versions {
+		if r.Comparable(version.Version) {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		return ret
+	}
+
+	//Versions are sorted, so the last one is the newest. Versions which the
+	// scheme orders as equal, such as 1.0 and 1.0.0, count as a single step.
+	newestIdx := len(versions) - 1
+	ret.Newest = versions[newestIdx].Version
+	behind := make([]int, len(versions))
+	for i := newestIdx - 1; i >= 0; i-- {
+		behind[i] = behind[i+1]
+		if r.CompareVersions(versions[i].Version, versions[i+1].Version) != 0 {
+			behind[i]++
+		}
+	}
+
+	for i := 0; i < newestIdx && behind[i] > 0; i++ {
+		for _, id := range versions[i].Deployments {
+			ret.Behind = append(ret.Behind, DriftReportDeployment{
+				ID:             id,
+				Version:        versions[i].Version,
+				VersionsBehind: behind[i],
+			})
+		}
+	}
+	sort.Slice(ret.Behind, func(i, j int) bool {
+		if ret.Behind[i].VersionsBehind != ret.Behind[j].VersionsBehind {
+			return ret.Behind[i].VersionsBehind > ret.Behind[j].VersionsBehind
+		}
+		return ret.Behind[i].ID < ret.Behind[j].ID
+	})
+
+	return ret
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/starkandwayne/signalfire/config"
+)
+
+func TestDriftReport(t *testing.T) {
+	tests := []struct {
+		name string
+		//versions of uaa, keyed by deployment name
+		versions map[string]string
+		newest   string
+		behind   []DriftReportDeployment
+		score    int
+	}{
+		{
+			name:     "in sync",
+			versions: map[string]string{"cf-1": "74.0.0", "cf-2": "74.0.0"},
+			newest:   "74.0.0",
+			behind:   []DriftReportDeployment{},
+		},
+		{
+			name:     "one behind",
+			versions: map[string]string{"cf-1": "74.0.0", "cf-2": "73.0.0"},
+			newest:   "74.0.0",
+			behind:   []DriftReportDeployment{{ID: "uuid/cf-2", Version: "73.0.0", VersionsBehind: 1}},
+			score:    1,
+		},
+		{
+			name:     "furthest behind first",
+			versions: map[string]string{"cf-1": "74.0.0", "cf-2": "73.0.0", "cf-3": "72.0.0", "cf-4": "73.0.0"},
+			newest:   "74.0.0",
+			behind: []DriftReportDeployment{
+				{ID: "uuid/cf-3", Version: "72.0.0", VersionsBehind: 2},
+				{ID: "uuid/cf-2", Version: "73.0.0", VersionsBehind: 1},
+				{ID: "uuid/cf-4", Version: "73.0.0", VersionsBehind: 1},
+			},
+			score: 4,
+		},
+		{
+			name:     "equal versions are not behind",
+			versions: map[string]string{"cf-1": "74.0", "cf-2": "74.0.0"},
+			behind:   []DriftReportDeployment{},
+		},
+		{
+			name:     "equal versions count as one step",
+			versions: map[string]string{"cf-1": "74.0.0", "cf-2": "73.0", "cf-3": "73.0.0", "cf-4": "72.0.0"},
+			newest:   "74.0.0",
+			behind: []DriftReportDeployment{
+				{ID: "uuid/cf-4", Version: "72.0.0", VersionsBehind: 2},
+				{ID: "uuid/cf-2", Version: "73.0", VersionsBehind: 1},
+				{ID: "uuid/cf-3", Version: "73.0.0", VersionsBehind: 1},
+			},
+			score: 4,
+		},
+	}
+
+	rules, err := NewCollationRules([]config.CollationRule{
+		{Type: config.CollationRuleTypeDeploymentRegex, Match: `^(\w+)-`},
+	})
+	if err != nil {
+		t.Fatalf("NewCollationRules: %s", err)
+	}
+
+	for _, test := range tests {
+		c := newTestCollator(rules)
+		env := CacheEnvironment{Name: "director", UUID: "uuid"}
+		for name, version := range test.versions {
+			env.Deployments = append(env.Deployments, CacheDeployment{
+				Name:     name,
+				Releases: CacheReleases{{Name: "uaa", Version: version}},
+			})
+		}
+		c.collateEnvironment(env)
+
+		report, err := c.GetDriftReport(DefaultCollationDimension, "cf")
+		if err != nil {
+			t.Fatalf("%s: GetDriftReport: %s", test.name, err)
+		}
+		if len(report.Releases) != 1 {
+			t.Fatalf("%s: Expected one release, got %+v", test.name, report.Releases)
+		}
+		release := report.Releases[0]
+		if test.newest != "" && release.Newest != test.newest {
+			t.Errorf("%s: Expected newest version %s, got %s", test.name, test.newest, release.Newest)
+		}
+		if !reflect.DeepEqual(release.Behind, test.behind) {
+			t.Errorf("%s: Behind:\nexpected: %+v\ngot:      %+v", test.name, test.behind, release.Behind)
+		}
+		if report.Score != test.score {
+			t.Errorf("%s: Expected score %d, got %d", test.name, test.score, report.Score)
+		}
+	}
+
+	c := newTestCollator(nil)
+	if _, err := c.GetDriftReport(DefaultCollationDimension, "cf"); err == nil {
+		t.Errorf("Expected an error for a missing group")
+	}
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"io/ioutil"
+
+	"github.com/starkandwayne/signalfire/log"
+)
+
+//CollationDryRun is the outcome of sorting deployments with a set of rules
+// that is not in use by any live Collator
+type CollationDryRun struct {
+	Groups []CollationDeploymentGroup
+	//Ungrouped holds the deployments which matched no rule, keyed by dimension
+	Ungrouped map[string][]CollationDeployment
+	Traces    []CollationTrace
+}
+
+//CollationTrace explains which rules matched a deployment
+type CollationTrace struct {
+	DeploymentID string
+	Matches      []CollationTraceMatch
+	//Errors holds the failures of rules which could not sort the deployment
+	Errors []CollationTraceError
+}
+
+type CollationTraceError struct {
+	RuleIdx int
+	Error   string
+}
+
+type CollationTraceMatch struct {
+	//RuleIdx is the index of the matching rule in the rule list
+	RuleIdx   int
+	Dimension string
+	Group     string
+	//Captures holds the submatches of regex rules, with the whole match first
+	Captures []string
+	//Applied is false if the match was ignored because an earlier rule had
+	// already matched in the same dimension
+	Applied bool
+}
+
+//DryRunCollation sorts the deployments of the given environments with the given
+// rules and mode, and traces how each deployment was sorted. Release versions
+// are ordered with the given version schemes.
+func DryRunCollation(envs []CacheEnvironment, rules []CollationRule, mode CollationMode, schemes *VersionSchemes) *CollationDryRun {
+	c := NewCollator(&log.Logger{Output: ioutil.Discard, Level: log.LevelFatal})
+	c.mode = mode
+	c.schemes = schemes
+	c.rules = rules
+	c.collate(envs)
+
+	ret := &CollationDryRun{
+		Groups:    c.groups,
+		Ungrouped: c.ungrouped,
+	}
+	for _, env := range envs {
+		for _, deployment := range c.flattenEnvDeployments(env) {
+			ret.Traces = append(ret.Traces, c.trace(deployment))
+		}
+	}
+
+	return ret
+}
+
+func (c *Collator) trace(deployment CollationDeploymentInput) CollationTrace {
+	ret := CollationTrace{DeploymentID: deployment.calcID()}
+	tags := c.calcDeploymentTags(deployment)
+	matched := map[string]bool{}
+	for i, rule := range c.rules {
+		group, err := collationRuleGroup(rule, deployment)
+		if err != nil {
+			ret.Errors = append(ret.Errors, CollationTraceError{RuleIdx: i, Error: err.Error()})
+		}
+		if group == "" {
+			continue
+		}
+
+		dimension := collationRuleDimension(rule)
+		toAdd := CollationTraceMatch{
+			RuleIdx:   i,
+			Dimension: dimension,
+			Group:     group,
+			Applied:   containsString(tags[dimension], group) && !matched[dimension+"/"+group],
+		}
+		if toAdd.Applied {
+			matched[dimension+"/"+group] = true
+		}
+		if capturer, isCapturing := rule.(capturingRule); isCapturing {
+			toAdd.Captures = capturer.captures(deployment)
+		}
+
+		ret.Matches = append(ret.Matches, toAdd)
+	}
+
+	return ret
+}
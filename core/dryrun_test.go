@@ -0,0 +1,108 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/starkandwayne/signalfire/config"
+)
+
+func TestDryRunCollationTraces(t *testing.T) {
+	rules, err := NewCollationRules([]config.CollationRule{
+		{Type: config.CollationRuleTypeDeploymentRegex, Match: `^(\w+)-`},
+		{Type: config.CollationRuleTypeRelease, Release: "uaa", Group: "identity"},
+		{Type: config.CollationRuleTypeDirectorRegex, Match: `^(\w+)-director`, Dimension: "env"},
+	})
+	if err != nil {
+		t.Fatalf("NewCollationRules: %s", err)
+	}
+
+	env := CacheEnvironment{
+		Name: "prod-director",
+		UUID: "uuid",
+		Deployments: CacheDeployments{
+			{Name: "cf-1", Releases: CacheReleases{{Name: "uaa", Version: "74.0.0"}}},
+			{Name: "redis", Releases: CacheReleases{{Name: "redis", Version: "15.0.0"}}},
+		},
+	}
+
+	tests := []struct {
+		mode   CollationMode
+		traces map[string][]CollationTraceMatch
+	}{
+		{
+			mode: CollationModeFirstMatch,
+			traces: map[string][]CollationTraceMatch{
+				"uuid/cf-1": {
+					{RuleIdx: 0, Dimension: "group", Group: "cf", Captures: []string{"cf-", "cf"}, Applied: true},
+					{RuleIdx: 1, Dimension: "group", Group: "identity", Applied: false},
+					{RuleIdx: 2, Dimension: "env", Group: "prod", Captures: []string{"prod-director", "prod"}, Applied: true},
+				},
+				"uuid/redis": {
+					{RuleIdx: 2, Dimension: "env", Group: "prod", Captures: []string{"prod-director", "prod"}, Applied: true},
+				},
+			},
+		},
+		{
+			mode: CollationModeTags,
+			traces: map[string][]CollationTraceMatch{
+				"uuid/cf-1": {
+					{RuleIdx: 0, Dimension: "group", Group: "cf", Captures: []string{"cf-", "cf"}, Applied: true},
+					{RuleIdx: 1, Dimension: "group", Group: "identity", Applied: true},
+					{RuleIdx: 2, Dimension: "env", Group: "prod", Captures: []string{"prod-director", "prod"}, Applied: true},
+				},
+				"uuid/redis": {
+					{RuleIdx: 2, Dimension: "env", Group: "prod", Captures: []string{"prod-director", "prod"}, Applied: true},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		result := DryRunCollation([]CacheEnvironment{env}, rules, test.mode, nil)
+		if len(result.Traces) != len(test.traces) {
+			t.Fatalf("Mode %d: expected %d traces, got %+v", test.mode, len(test.traces), result.Traces)
+		}
+		for _, trace := range result.Traces {
+			want := test.traces[trace.DeploymentID]
+			if !reflect.DeepEqual(trace.Matches, want) {
+				t.Errorf("Mode %d: trace of `%s':\nexpected: %+v\ngot:      %+v",
+					test.mode, trace.DeploymentID, want, trace.Matches)
+			}
+		}
+
+		ungrouped := result.Ungrouped[DefaultCollationDimension]
+		if len(ungrouped) != 1 || ungrouped[0].Name != "redis" {
+			t.Errorf("Mode %d: expected redis to be ungrouped, got %+v", test.mode, ungrouped)
+		}
+		if len(result.Ungrouped["env"]) != 0 {
+			t.Errorf("Mode %d: expected nothing ungrouped in env, got %+v", test.mode, result.Ungrouped["env"])
+		}
+	}
+}
+
+func TestDryRunCollationGroups(t *testing.T) {
+	rules, err := NewCollationRules([]config.CollationRule{
+		{Type: config.CollationRuleTypeNameMap, Map: map[string]string{"cf-1": "cf", "cf-2": "cf"}},
+	})
+	if err != nil {
+		t.Fatalf("NewCollationRules: %s", err)
+	}
+
+	envs := []CacheEnvironment{
+		{Name: "a", UUID: "uuid-a", Deployments: CacheDeployments{{Name: "cf-1"}}},
+		{Name: "b", UUID: "uuid-b", Deployments: CacheDeployments{{Name: "cf-2"}, {Name: "cf-3"}}},
+	}
+	result := DryRunCollation(envs, rules, CollationModeFirstMatch, nil)
+	if len(result.Groups) != 1 || result.Groups[0].Name != "cf" || len(result.Groups[0].Deployments) != 2 {
+		t.Errorf("Expected one group with both cf deployments, got %+v", result.Groups)
+	}
+	if len(result.Traces) != 3 {
+		t.Errorf("Expected a trace for every deployment, got %+v", result.Traces)
+	}
+	for _, trace := range result.Traces {
+		if trace.DeploymentID == "uuid-b/cf-3" && len(trace.Matches) != 0 {
+			t.Errorf("Expected no matches for cf-3, got %+v", trace.Matches)
+		}
+	}
+}
@@ -1,31 +1,62 @@
 package core
 
 import (
-	"regexp"
 	"sort"
-	"strconv"
-	"strings"
+	"time"
 )
 
 type CollationDeploymentGroup struct {
+	//Dimension is the tag dimension that this group was sorted along
+	Dimension   string
 	Name        string
 	Deployments []CollationDeployment
 	Releases    []CollationRelease
+	Stemcells   []CollationStemcell
+	//PolicyViolations is the number of policy violations in the group. It is
+	// only set on groups returned by the Collator.
+	PolicyViolations int
 }
 
-func newCollationDeploymentGroup(name string) *CollationDeploymentGroup {
-	return &CollationDeploymentGroup{Name: name}
+func newCollationDeploymentGroup(dimension, name string) *CollationDeploymentGroup {
+	return &CollationDeploymentGroup{Dimension: dimension, Name: name}
 }
 
-func (c *CollationDeploymentGroup) addDeployment(in CollationDeploymentInput) {
-	dep := CollationDeployment{
-		ID:           in.calcID(),
-		Name:         in.DeploymentName,
-		DirectorUUID: in.DirectorUUID,
+//copy returns a deep copy of the group, which is safe to read while the
+// collator continues to modify the original
+func (c CollationDeploymentGroup) copy() CollationDeploymentGroup {
+	ret := c
+	ret.Deployments = make([]CollationDeployment, len(c.Deployments))
+	copy(ret.Deployments, c.Deployments)
+	ret.Releases = make([]CollationRelease, 0, len(c.Releases))
+	for _, release := range c.Releases {
+		ret.Releases = append(ret.Releases, release.copy())
 	}
+	ret.Stemcells = make([]CollationStemcell, 0, len(c.Stemcells))
+	for _, stemcell := range c.Stemcells {
+		ret.Stemcells = append(ret.Stemcells, stemcell.copy())
+	}
+
+	return ret
+}
+
+//InstanceHealth sums the healthy and unhealthy instances of the deployments in
+// the group
+func (c CollationDeploymentGroup) InstanceHealth() (healthy, unhealthy int) {
+	for _, dep := range c.Deployments {
+		healthy += dep.HealthyInstances
+		unhealthy += dep.UnhealthyInstances
+	}
+
+	return
+}
+
+func (c *CollationDeploymentGroup) addDeployment(dep CollationDeployment, in CollationDeploymentInput, schemes *VersionSchemes) {
 	c.Deployments = append(c.Deployments, dep)
 	for _, release := range in.Releases {
-		c.addRelease(dep.ID, release)
+		c.addRelease(dep.ID, release, schemes.For(release.Name))
+	}
+	for _, stemcell := range in.Stemcells {
+		c.addStemcell(dep.ID, stemcell, schemes.ForStemcells())
 	}
 }
 
@@ -41,6 +72,7 @@ func (c *CollationDeploymentGroup) removeDeploymentByID(id string) bool {
 
 	if len(c.Deployments) == 0 {
 		c.Releases = nil
+		c.Stemcells = nil
 		return true
 	}
 
@@ -52,6 +84,14 @@ func (c *CollationDeploymentGroup) removeDeploymentByID(id string) bool {
 		}
 	}
 	c.Releases = newReleaseList
+
+	newStemcellList := []CollationStemcell{}
+	for i := range c.Stemcells {
+		if !c.Stemcells[i].removeDeploymentByID(id) {
+			newStemcellList = append(newStemcellList, c.Stemcells[i])
+		}
+	}
+	c.Stemcells = newStemcellList
 	return false
 }
 
@@ -67,73 +107,208 @@ func (c *CollationDeploymentGroup) findReleaseIdxByName(name string) int {
 	return ret
 }
 
-func (c *CollationDeploymentGroup) addRelease(deploymentID string, release CacheRelease) {
+func (c *CollationDeploymentGroup) addRelease(deploymentID string, release CacheRelease, scheme VersionScheme) {
 	relIdx := c.findReleaseIdxByName(release.Name)
 	if relIdx < 0 {
 		c.Releases = append(c.Releases, CollationRelease{
-			Name: release.Name,
+			Name:   release.Name,
+			scheme: scheme,
 		})
 		relIdx = len(c.Releases) - 1
 	}
 	c.Releases[relIdx].addDeploymentVersion(deploymentID, release.Version)
 }
 
+func (c *CollationDeploymentGroup) addStemcell(deploymentID string, stemcell CacheStemcell, scheme VersionScheme) {
+	idx := -1
+	for i := range c.Stemcells {
+		if c.Stemcells[i].OS == stemcell.OS {
+			idx = i
+			break
+		}
+	}
+
+	if idx < 0 {
+		c.Stemcells = append(c.Stemcells, CollationStemcell{
+			OS:     stemcell.OS,
+			scheme: scheme,
+		})
+		idx = len(c.Stemcells) - 1
+	}
+	c.Stemcells[idx].addDeploymentVersion(deploymentID, stemcell.Version)
+}
+
 type CollationDeployment struct {
 	ID           string
 	Name         string
 	DirectorUUID string
+	DirectorName string
+	//Tags holds the names of every group that this deployment is in, keyed by
+	// dimension
+	Tags               map[string][]string
+	HealthyInstances   int
+	UnhealthyInstances int
+	LastDeployedAt     time.Time
+	LastDeployFailed   bool
+}
+
+func newCollationDeployment(in CollationDeploymentInput) CollationDeployment {
+	return CollationDeployment{
+		ID:                 in.calcID(),
+		Name:               in.DeploymentName,
+		DirectorUUID:       in.DirectorUUID,
+		DirectorName:       in.DirectorName,
+		HealthyInstances:   in.HealthyInstances,
+		UnhealthyInstances: in.UnhealthyInstances,
+		LastDeployedAt:     in.LastDeployedAt,
+		LastDeployFailed:   in.LastDeployFailed,
+	}
 }
 
 type CollationRelease struct {
 	Name     string
 	Versions []CollationReleaseVersion
+	scheme   VersionScheme
 }
 
-func (r *CollationRelease) findReleaseVersionIdx(version string) int {
-	for i := range r.Versions {
-		if r.Versions[i].Version == version {
+//CompareVersions orders two versions of this release by its version scheme
+func (r CollationRelease) CompareVersions(v1, v2 string) int {
+	if r.scheme == nil {
+		return LegacyVersionScheme{}.Compare(v1, v2)
+	}
+
+	return r.scheme.Compare(v1, v2)
+}
+
+//Comparable returns false if the version scheme of this release cannot order
+// the version, such as a commit SHA build
+func (r CollationRelease) Comparable(version string) bool {
+	return r.scheme == nil || r.scheme.Parses(version)
+}
+
+//setScheme changes the version scheme of the release and re-sorts its versions
+func (r *CollationRelease) setScheme(scheme VersionScheme) {
+	r.scheme = scheme
+	sort.SliceStable(r.Versions, func(i, j int) bool {
+		return r.CompareVersions(r.Versions[i].Version, r.Versions[j].Version) < 0
+	})
+}
+
+func (r CollationRelease) copy() CollationRelease {
+	ret := r
+	ret.Versions = copyVersions(r.Versions)
+	return ret
+}
+
+func (r *CollationRelease) addDeploymentVersion(id, version string) {
+	r.Versions = addVersionDeployment(r.Versions, r.CompareVersions, id, version)
+}
+
+//removeDeploymentByID returns true if the release is empty of versions
+// after the deletion
+func (r *CollationRelease) removeDeploymentByID(id string) bool {
+	r.Versions = removeVersionDeployment(r.Versions, id)
+	return len(r.Versions) == 0
+}
+
+//CollationStemcell holds the versions of one stemcell operating system in use
+// by the deployments of a group
+type CollationStemcell struct {
+	OS       string
+	Versions []CollationReleaseVersion
+	scheme   VersionScheme
+}
+
+//CompareVersions orders two versions of this stemcell by its version scheme
+func (s CollationStemcell) CompareVersions(v1, v2 string) int {
+	if s.scheme == nil {
+		return LegacyVersionScheme{}.Compare(v1, v2)
+	}
+
+	return s.scheme.Compare(v1, v2)
+}
+
+//setScheme changes the version scheme of the stemcell and re-sorts its
+// versions
+func (s *CollationStemcell) setScheme(scheme VersionScheme) {
+	s.scheme = scheme
+	sort.SliceStable(s.Versions, func(i, j int) bool {
+		return s.CompareVersions(s.Versions[i].Version, s.Versions[j].Version) < 0
+	})
+}
+
+func (s CollationStemcell) copy() CollationStemcell {
+	ret := s
+	ret.Versions = copyVersions(s.Versions)
+	return ret
+}
+
+func (s *CollationStemcell) addDeploymentVersion(id, version string) {
+	s.Versions = addVersionDeployment(s.Versions, s.CompareVersions, id, version)
+}
+
+//removeDeploymentByID returns true if the stemcell is empty of versions after
+// the deletion
+func (s *CollationStemcell) removeDeploymentByID(id string) bool {
+	s.Versions = removeVersionDeployment(s.Versions, id)
+	return len(s.Versions) == 0
+}
+
+func copyVersions(versions []CollationReleaseVersion) []CollationReleaseVersion {
+	ret := make([]CollationReleaseVersion, 0, len(versions))
+	for _, version := range versions {
+		deployments := make([]string, len(version.Deployments))
+		copy(deployments, version.Deployments)
+		version.Deployments = deployments
+		ret = append(ret, version)
+	}
+
+	return ret
+}
+
+func findVersionIdx(versions []CollationReleaseVersion, version string) int {
+	for i := range versions {
+		if versions[i].Version == version {
 			return i
 		}
 	}
 	return -1
 }
 
-func (r *CollationRelease) addDeploymentVersion(id, version string) {
-	vIdx := r.findReleaseVersionIdx(version)
+//addVersionDeployment adds the deployment to the given version, which is
+// inserted in sorted position if it is not yet in the list, so the versions
+// never need to be re-sorted as a whole
+func addVersionDeployment(versions []CollationReleaseVersion, compare func(v1, v2 string) int, id, version string) []CollationReleaseVersion {
+	vIdx := findVersionIdx(versions, version)
 	if vIdx < 0 {
-		r.Versions = append(r.Versions, CollationReleaseVersion{Version: version})
-		vIdx = len(r.Versions) - 1
+		toAdd := CollationReleaseVersion{Version: version}
+		vIdx = sort.Search(len(versions), func(i int) bool {
+			return compare(version, versions[i].Version) < 0
+		})
+		versions = append(versions, CollationReleaseVersion{})
+		copy(versions[vIdx+1:], versions[vIdx:])
+		versions[vIdx] = toAdd
 	}
 
-	r.Versions[vIdx].addDeployment(id)
-	r.sortVersions()
+	versions[vIdx].addDeployment(id)
+	return versions
 }
 
-//removeDeploymentByID returns true if the release is empty of versions
-// after the deletion
-func (r *CollationRelease) removeDeploymentByID(id string) bool {
-	for i := range r.Versions {
-		if r.Versions[i].findDeploymentIdx(id) < 0 {
+func removeVersionDeployment(versions []CollationReleaseVersion, id string) []CollationReleaseVersion {
+	for i := range versions {
+		if versions[i].findDeploymentIdx(id) < 0 {
 			continue
 		}
 
-		noRemainingDeploymentsForVersion := r.Versions[i].removeDeployment(id)
+		noRemainingDeploymentsForVersion := versions[i].removeDeployment(id)
 		if noRemainingDeploymentsForVersion {
-			lastIdx := len(r.Versions) - 1
-			r.Versions[i], r.Versions[lastIdx] = r.Versions[lastIdx], r.Versions[i]
-			r.Versions = r.Versions[:lastIdx]
+			//Preserve the order, so that the versions stay sorted
+			versions = append(versions[:i], versions[i+1:]...)
 		}
 		break
 	}
 
-	r.sortVersions()
-	return len(r.Versions) == 0
-}
-
-func (r *CollationRelease) sortVersions() {
-	sort.Slice(r.Versions, func(i, j int) bool {
-		return r.Versions[i].LessThan(r.Versions[j])
-	})
+	return versions
 }
 
 type CollationReleaseVersion struct {
@@ -141,6 +316,13 @@ type CollationReleaseVersion struct {
 	//Deployments is a list of deployment IDs using this
 	// release version
 	Deployments []string
+	//CatalogStatus compares this version to the latest version in the release
+	// catalog. It is only set on groups returned by the Collator, and only if a
+	// catalog is configured.
+	CatalogStatus string
+	//Advisories lists the security advisories affecting this version. It is
+	// only set on groups returned by the Collator.
+	Advisories []Advisory
 }
 
 func (v *CollationReleaseVersion) addDeployment(id string) {
@@ -168,73 +350,7 @@ func (v *CollationReleaseVersion) findDeploymentIdx(id string) int {
 	return -1
 }
 
+//LessThan compares versions with the LegacyVersionScheme
 func (v1 CollationReleaseVersion) LessThan(v2 CollationReleaseVersion) bool {
-	n1, rc1 := v1.parseVersionAndRC()
-	n2, rc2 := v2.parseVersionAndRC()
-	vDiff := v1.versionNumDiff(n1, n2)
-	if vDiff == 0 {
-		//A non-rc is greater than an rc
-		if len(rc1) == 0 {
-			return false
-		}
-		if len(rc2) == 0 {
-			return true
-		}
-		vDiff = v1.versionNumDiff(rc1, rc2)
-	}
-	return vDiff < 0
-}
-
-func (v CollationReleaseVersion) parseVersionAndRC() (version []int64, rc []int64) {
-	matches := regexp.MustCompile("rc[^0-9]*(.*)$").FindStringSubmatch(v.Version)
-	if len(matches) > 0 {
-		v.Version = strings.Replace(v.Version, matches[0], "", -1)
-		rc = []int64{0}
-		if len(matches) > 1 {
-			rc = v.parseVersionString(matches[1])
-		}
-	}
-	version = v.parseVersionString(v.Version)
-	return
-}
-
-func (CollationReleaseVersion) parseVersionString(version string) []int64 {
-	numberedComponents := regexp.MustCompile("[^0-9]+").Split(version, -1)
-	if len(numberedComponents) == 0 {
-		return []int64{0}
-	}
-	ret := make([]int64, len(numberedComponents))
-	for i, numStr := range numberedComponents {
-		ret[i], _ = strconv.ParseInt(numStr, 10, 64)
-	}
-
-	return ret
-}
-
-// versionNumDiff returns
-// < 0 if v1 < v2
-// 0   if v1 == v2
-// > 0 if v1 > v2
-func (CollationReleaseVersion) versionNumDiff(v1, v2 []int64) int64 {
-	maxLen := len(v1)
-	if len(v2) > maxLen {
-		maxLen = len(v2)
-	}
-
-	for i := 0; i < maxLen; i++ {
-		//Default the point to 0, in the case that both versions don't have the
-		// same number of points
-		var v1Val, v2Val int64 = 0, 0
-		if len(v1) > i {
-			v1Val = v1[i]
-		}
-		if len(v2) > i {
-			v2Val = v2[i]
-		}
-		if v1Val != v2Val {
-			return v1Val - v2Val
-		}
-	}
-
-	return 0
+	return LegacyVersionScheme{}.Compare(v1.Version, v2.Version) < 0
 }
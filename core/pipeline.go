@@ -0,0 +1,290 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/starkandwayne/signalfire/config"
+)
+
+//Statuses that a pipeline stage can have for a release
+const (
+	//PipelineStatusInSync means the stage runs the same version as the stages
+	// around it
+	PipelineStatusInSync = "in_sync"
+	//PipelineStatusAhead means the stage runs a newer version than the next
+	// stage, which is waiting to be promoted
+	PipelineStatusAhead = "ahead"
+	//PipelineStatusBehind means the stage runs an older version than the
+	// previous stage
+	PipelineStatusBehind = "behind"
+	//PipelineStatusSkipping means the stage runs a newer version than the
+	// previous stage, so that version skipped the previous stage
+	PipelineStatusSkipping = "skipping"
+	//PipelineStatusMissing means no deployment in the stage has the release
+	PipelineStatusMissing = "missing"
+	//PipelineStatusUnordered means the stage runs a version which the version
+	// scheme of the release cannot order, such as a commit SHA build
+	PipelineStatusUnordered = "unordered"
+)
+
+//Pipeline orders the deployments of matching groups into stages
+type Pipeline struct {
+	Group     *regexp.Regexp
+	Dimension string
+	Stages    []PipelineStage
+}
+
+type PipelineStage struct {
+	Name           string
+	DeploymentName *regexp.Regexp
+	DirectorName   *regexp.Regexp
+}
+
+//NewPipelines validates the given pipeline configurations and returns the
+// pipelines that they describe, in the same order.
+func NewPipelines(confs []config.Pipeline) ([]Pipeline, error) {
+	ret := make([]Pipeline, 0, len(confs))
+	for i, conf := range confs {
+		pipeline, err := NewPipeline(conf)
+		if err != nil {
+			return nil, fmt.Errorf("Pipeline at index %d: %s", i, err)
+		}
+
+		ret = append(ret, *pipeline)
+	}
+
+	return ret, nil
+}
+
+func NewPipeline(conf config.Pipeline) (*Pipeline, error) {
+	if conf.Group == "" {
+		return nil, fmt.Errorf("A `group' regex is required")
+	}
+
+	group, err := regexp.Compile(conf.Group)
+	if err != nil {
+		return nil, fmt.Errorf("Could not compile `group' regex: %s", err)
+	}
+
+	if len(conf.Stages) < 2 {
+		return nil, fmt.Errorf("At least two stages are required")
+	}
+
+	ret := &Pipeline{
+		Group:     group,
+		Dimension: conf.Dimension,
+	}
+	if ret.Dimension == "" {
+		ret.Dimension = DefaultCollationDimension
+	}
+
+	for i, stageConf := range conf.Stages {
+		stage, err := newPipelineStage(stageConf)
+		if err != nil {
+			return nil, fmt.Errorf("Stage at index %d: %s", i, err)
+		}
+		ret.Stages = append(ret.Stages, *stage)
+	}
+
+	return ret, nil
+}
+
+func newPipelineStage(conf config.PipelineStage) (*PipelineStage, error) {
+	if conf.Name == "" {
+		return nil, fmt.Errorf("A `name' is required")
+	}
+
+	if conf.DeploymentName == "" && conf.DirectorName == "" {
+		return nil, fmt.Errorf("One of `deployment_name' or `director_name' is required")
+	}
+
+	ret := &PipelineStage{Name: conf.Name}
+	var err error
+	if conf.DeploymentName != "" {
+		ret.DeploymentName, err = regexp.Compile(conf.DeploymentName)
+		if err != nil {
+			return nil, fmt.Errorf("Could not compile `deployment_name' regex: %s", err)
+		}
+	}
+
+	if conf.DirectorName != "" {
+		ret.DirectorName, err = regexp.Compile(conf.DirectorName)
+		if err != nil {
+			return nil, fmt.Errorf("Could not compile `director_name' regex: %s", err)
+		}
+	}
+
+	return ret, nil
+}
+
+func (p *Pipeline) appliesTo(group *CollationDeploymentGroup) bool {
+	return p.Dimension == group.Dimension && p.Group.MatchString(group.Name)
+}
+
+//stageIdx returns the index of the first stage matching the deployment, or
+// negative if no stage matches
+func (p *Pipeline) stageIdx(dep CollationDeployment) int {
+	for i, stage := range p.Stages {
+		if stage.DeploymentName != nil && !stage.DeploymentName.MatchString(dep.Name) {
+			continue
+		}
+		if stage.DirectorName != nil && !stage.DirectorName.MatchString(dep.DirectorName) {
+			continue
+		}
+		return i
+	}
+
+	return -1
+}
+
+type PipelineReport struct {
+	Group     string
+	Dimension string
+	Stages    []PipelineReportStage
+	Releases  []PipelineReportRelease
+	//Unstaged lists the IDs of deployments in the group which matched no stage
+	Unstaged []string
+}
+
+type PipelineReportStage struct {
+	Name string
+	//Deployments lists the IDs of the deployments in this stage
+	Deployments []string
+}
+
+type PipelineReportRelease struct {
+	Name string
+	//Stages has an entry for each stage of the pipeline, in order
+	Stages []PipelineReportReleaseStage
+}
+
+type PipelineReportReleaseStage struct {
+	Stage string
+	//Version is the newest version of the release in this stage. It is empty if
+	// the status is PipelineStatusMissing.
+	Version string
+	Status  string
+}
+
+func (c *Collator) AddPipeline(pipeline Pipeline) {
+	c.lock.Lock()
+	c.pipelines = append(c.pipelines, pipeline)
+	c.lock.Unlock()
+}
+
+//GetPipelineReport compares the release versions between the stages of the
+// pipeline configured for the named group
+func (c *Collator) GetPipelineReport(dimension, groupName string) (*PipelineReport, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	groupIdx := c.findGroupIdx(collationGroupKey{Dimension: dimension, Name: groupName})
+	if groupIdx < 0 {
+		return nil, fmt.Errorf("No group with name `%s' in dimension `%s'", groupName, dimension)
+	}
+	group := &c.groups[groupIdx]
+
+	var pipeline *Pipeline
+	for i := range c.pipelines {
+		if c.pipelines[i].appliesTo(group) {
+			pipeline = &c.pipelines[i]
+			break
+		}
+	}
+	if pipeline == nil {
+		return nil, fmt.Errorf("No pipeline is configured for group `%s'", groupName)
+	}
+
+	return pipeline.report(group), nil
+}
+
+func (p *Pipeline) report(group *CollationDeploymentGroup) *PipelineReport {
+	ret := &PipelineReport{
+		Group:     group.Name,
+		Dimension: group.Dimension,
+		Stages:    make([]PipelineReportStage, len(p.Stages)),
+		Unstaged:  []string{},
+	}
+	for i, stage := range p.Stages {
+		ret.Stages[i] = PipelineReportStage{Name: stage.Name, Deployments: []string{}}
+	}
+
+	stageOf := map[string]int{}
+	for _, dep := range group.Deployments {
+		idx := p.stageIdx(dep)
+		if idx < 0 {
+			ret.Unstaged = append(ret.Unstaged, dep.ID)
+			continue
+		}
+
+		stageOf[dep.ID] = idx
+		ret.Stages[idx].Deployments = append(ret.Stages[idx].Deployments, dep.ID)
+	}
+
+	for _, release := range group.Releases {
+		ret.Releases = append(ret.Releases, p.reportRelease(release, stageOf))
+	}
+
+	return ret
+}
+
+func (p *Pipeline) reportRelease(release CollationRelease, stageOf map[string]int) PipelineReportRelease {
+	//Versions are sorted, so the last version seen for a stage is its newest
+	newest := make([]*CollationReleaseVersion, len(p.Stages))
+	for i := range release.Versions {
+		for _, depID := range release.Versions[i].Deployments {
+			if stageIdx, staged := stageOf[depID]; staged {
+				newest[stageIdx] = &release.Versions[i]
+			}
+		}
+	}
+
+	ret := PipelineReportRelease{Name: release.Name}
+	for i, stage := range p.Stages {
+		toAdd := PipelineReportReleaseStage{
+			Stage:  stage.Name,
+			Status: PipelineStatusMissing,
+		}
+
+		switch {
+		case newest[i] == nil:
+		case !release.Comparable(newest[i].Version):
+			toAdd.Version = newest[i].Version
+			toAdd.Status = PipelineStatusUnordered
+		default:
+			toAdd.Version = newest[i].Version
+			toAdd.Status = pipelineStatus(release, newest[i],
+				nearestVersion(release, newest, i, -1), nearestVersion(release, newest, i, 1))
+		}
+
+		ret.Stages = append(ret.Stages, toAdd)
+	}
+
+	return ret
+}
+
+//nearestVersion walks from idx in the given direction, and returns the first
+// comparable version found, or nil if there is none
+func nearestVersion(release CollationRelease, versions []*CollationReleaseVersion, idx, direction int) *CollationReleaseVersion {
+	for i := idx + direction; i >= 0 && i < len(versions); i += direction {
+		if versions[i] != nil && release.Comparable(versions[i].Version) {
+			return versions[i]
+		}
+	}
+
+	return nil
+}
+
+func pipelineStatus(release CollationRelease, version, previous, next *CollationReleaseVersion) string {
+	switch {
+	case previous != nil && release.CompareVersions(previous.Version, version.Version) < 0:
+		return PipelineStatusSkipping
+	case previous != nil && release.CompareVersions(version.Version, previous.Version) < 0:
+		return PipelineStatusBehind
+	case next != nil && release.CompareVersions(next.Version, version.Version) < 0:
+		return PipelineStatusAhead
+	}
+
+	return PipelineStatusInSync
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/starkandwayne/signalfire/config"
+)
+
+func newPipelineCollator(t *testing.T) *Collator {
+	rules, err := NewCollationRules([]config.CollationRule{
+		{Type: config.CollationRuleTypeDeploymentRegex, Match: `^(\w+)-`},
+	})
+	if err != nil {
+		t.Fatalf("NewCollationRules: %s", err)
+	}
+
+	pipelines, err := NewPipelines([]config.Pipeline{{
+		Group: "^cf$",
+		Stages: []config.PipelineStage{
+			{Name: "dev", DeploymentName: "-dev$"},
+			{Name: "staging", DeploymentName: "-staging$"},
+			{Name: "prod", DeploymentName: "-prod$"},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("NewPipelines: %s", err)
+	}
+
+	c := newTestCollator(rules)
+	for _, pipeline := range pipelines {
+		c.AddPipeline(pipeline)
+	}
+
+	return c
+}
+
+func TestPipelineReport(t *testing.T) {
+	tests := []struct {
+		name string
+		//versions of uaa in the dev, staging, and prod stages, where an empty
+		// version means the stage has no deployment
+		versions [3]string
+		statuses [3]string
+	}{
+		{
+			name:     "all stages equal",
+			versions: [3]string{"74.0.0", "74.0.0", "74.0.0"},
+			statuses: [3]string{PipelineStatusInSync, PipelineStatusInSync, PipelineStatusInSync},
+		},
+		{
+			name:     "new version in dev",
+			versions: [3]string{"75.0.0", "74.0.0", "74.0.0"},
+			statuses: [3]string{PipelineStatusAhead, PipelineStatusBehind, PipelineStatusInSync},
+		},
+		{
+			name:     "promoted to staging",
+			versions: [3]string{"75.0.0", "75.0.0", "74.0.0"},
+			statuses: [3]string{PipelineStatusInSync, PipelineStatusAhead, PipelineStatusBehind},
+		},
+		{
+			name:     "prod skipped staging",
+			versions: [3]string{"75.0.0", "74.0.0", "75.0.0"},
+			statuses: [3]string{PipelineStatusAhead, PipelineStatusBehind, PipelineStatusSkipping},
+		},
+		{
+			name:     "staging missing",
+			versions: [3]string{"75.0.0", "", "74.0.0"},
+			statuses: [3]string{PipelineStatusAhead, PipelineStatusMissing, PipelineStatusBehind},
+		},
+	}
+
+	stages := [3]string{"dev", "staging", "prod"}
+	for _, test := range tests {
+		c := newPipelineCollator(t)
+		env := CacheEnvironment{Name: "director", UUID: "uuid"}
+		for i, version := range test.versions {
+			if version == "" {
+				continue
+			}
+			env.Deployments = append(env.Deployments, CacheDeployment{
+				Name:     "cf-" + stages[i],
+				Releases: CacheReleases{{Name: "uaa", Version: version}},
+			})
+		}
+		c.collateEnvironment(env)
+
+		report, err := c.GetPipelineReport(DefaultCollationDimension, "cf")
+		if err != nil {
+			t.Fatalf("%s: GetPipelineReport: %s", test.name, err)
+		}
+		if len(report.Releases) != 1 || len(report.Releases[0].Stages) != 3 {
+			t.Fatalf("%s: Unexpected releases %+v", test.name, report.Releases)
+		}
+		for i, stage := range report.Releases[0].Stages {
+			if stage.Stage != stages[i] || stage.Version != test.versions[i] || stage.Status != test.statuses[i] {
+				t.Errorf("%s: Stage %d: expected %s at `%s' to be %s, got %+v",
+					test.name, i, stages[i], test.versions[i], test.statuses[i], stage)
+			}
+		}
+	}
+}
+
+func TestPipelineReportStaging(t *testing.T) {
+	c := newPipelineCollator(t)
+	c.collateEnvironment(CacheEnvironment{
+		Name: "director",
+		UUID: "uuid",
+		Deployments: CacheDeployments{
+			{Name: "cf-dev", Releases: CacheReleases{{Name: "uaa", Version: "74.0.0"}}},
+			{Name: "cf-sandbox", Releases: CacheReleases{{Name: "uaa", Version: "70.0.0"}}},
+			{Name: "redis-dev", Releases: CacheReleases{{Name: "redis", Version: "15.0.0"}}},
+		},
+	})
+
+	report, err := c.GetPipelineReport(DefaultCollationDimension, "cf")
+	if err != nil {
+		t.Fatalf("GetPipelineReport: %s", err)
+	}
+	if len(report.Stages[0].Deployments) != 1 || report.Stages[0].Deployments[0] != "uuid/cf-dev" {
+		t.Errorf("Unexpected dev stage %+v", report.Stages[0])
+	}
+	if len(report.Unstaged) != 1 || report.Unstaged[0] != "uuid/cf-sandbox" {
+		t.Errorf("Expected cf-sandbox to be unstaged, got %v", report.Unstaged)
+	}
+	//Versions of unstaged deployments do not count towards any stage
+	if got := report.Releases[0].Stages[0]; got.Version != "74.0.0" || got.Status != PipelineStatusInSync {
+		t.Errorf("Unexpected dev stage of uaa %+v", got)
+	}
+
+	if _, err := c.GetPipelineReport(DefaultCollationDimension, "redis"); err == nil {
+		t.Errorf("Expected an error for a group without a pipeline")
+	}
+	if _, err := c.GetPipelineReport(DefaultCollationDimension, "nope"); err == nil {
+		t.Errorf("Expected an error for a missing group")
+	}
+}
+
+func TestNewPipelineRejectsInvalidPipelines(t *testing.T) {
+	stages := []config.PipelineStage{
+		{Name: "dev", DeploymentName: "dev"},
+		{Name: "prod", DeploymentName: "prod"},
+	}
+	confs := []config.Pipeline{
+		{Stages: stages},
+		{Group: "(", Stages: stages},
+		{Group: "cf", Stages: stages[:1]},
+		{Group: "cf", Stages: []config.PipelineStage{{Name: "dev", DeploymentName: "dev"}, {Name: "prod"}}},
+		{Group: "cf", Stages: []config.PipelineStage{{DeploymentName: "dev"}, {Name: "prod", DeploymentName: "prod"}}},
+		{Group: "cf", Stages: []config.PipelineStage{{Name: "dev", DirectorName: "["}, {Name: "prod", DeploymentName: "prod"}}},
+	}
+
+	for i, conf := range confs {
+		if _, err := NewPipeline(conf); err == nil {
+			t.Errorf("Expected pipeline %d to be rejected", i)
+		}
+	}
+}
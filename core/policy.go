@@ -0,0 +1,202 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+
+	"github.com/starkandwayne/signalfire/config"
+)
+
+//Policy constrains the release versions that deployments in matching groups
+// may run
+type Policy struct {
+	Name      string
+	Group     string
+	Dimension string
+	Release   string
+	//MinVersion is empty if the policy does not require a minimum version
+	MinVersion string
+	//ForbidVersion is nil if the policy does not forbid any versions
+	ForbidVersion *regexp.Regexp
+}
+
+//PolicyViolation is a deployment running a release version that a policy does
+// not allow
+type PolicyViolation struct {
+	Policy       string
+	Dimension    string
+	Group        string
+	DeploymentID string
+	Release      string
+	Version      string
+	//Required describes the versions that the policy allows, such as
+	// ">= 74.0.0" or "not matching rc"
+	Required string
+}
+
+//NewPolicies validates the given policy configurations and returns the
+// policies that they describe, in the same order.
+func NewPolicies(confs []config.Policy) ([]Policy, error) {
+	ret := make([]Policy, 0, len(confs))
+	for i, conf := range confs {
+		policy, err := NewPolicy(conf)
+		if err != nil {
+			return nil, fmt.Errorf("Policy at index %d: %s", i, err)
+		}
+
+		ret = append(ret, *policy)
+	}
+
+	return ret, nil
+}
+
+func NewPolicy(conf config.Policy) (*Policy, error) {
+	if conf.Name == "" {
+		return nil, fmt.Errorf("A `name' is required")
+	}
+
+	ret := &Policy{
+		Name:       conf.Name,
+		Group:      conf.Group,
+		Dimension:  conf.Dimension,
+		Release:    conf.Release,
+		MinVersion: conf.MinVersion,
+	}
+	if ret.Group == "" {
+		ret.Group = "*"
+	}
+	if ret.Dimension == "" {
+		ret.Dimension = DefaultCollationDimension
+	}
+	if ret.Release == "" {
+		ret.Release = "*"
+	}
+
+	//path.Match only reports bad patterns when it gets as far as the bad part,
+	// so match against the empty string to check the whole pattern
+	for _, glob := range []string{ret.Group, ret.Release} {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("Bad glob `%s': %s", glob, err)
+		}
+	}
+
+	if conf.MinVersion == "" && conf.ForbidVersion == "" {
+		return nil, fmt.Errorf("One of `min_version' or `forbid_version' is required")
+	}
+
+	if conf.ForbidVersion != "" {
+		var err error
+		ret.ForbidVersion, err = regexp.Compile(conf.ForbidVersion)
+		if err != nil {
+			return nil, fmt.Errorf("Could not compile `forbid_version' regex: %s", err)
+		}
+	}
+
+	return ret, nil
+}
+
+func (p *Policy) appliesTo(group *CollationDeploymentGroup) bool {
+	matched, _ := path.Match(p.Group, group.Name)
+	return matched && p.Dimension == group.Dimension
+}
+
+func (p *Policy) appliesToRelease(release string) bool {
+	matched, _ := path.Match(p.Release, release)
+	return matched
+}
+
+//check returns a description of the versions that the policy allows, and
+// whether the given version is one of them
+func (p *Policy) check(release *CollationRelease, version string) (string, bool) {
+	//Incomparable versions are neither above nor below the minimum
+	if p.MinVersion != "" && release.Comparable(version) && release.CompareVersions(version, p.MinVersion) < 0 {
+		return ">= " + p.MinVersion, false
+	}
+
+	if p.ForbidVersion != nil && p.ForbidVersion.MatchString(version) {
+		return "not matching " + p.ForbidVersion.String(), false
+	}
+
+	return "", true
+}
+
+func (c *Collator) AddPolicy(policy Policy) {
+	c.lock.Lock()
+	c.policies = append(c.policies, policy)
+	c.evaluatePolicies()
+	c.lock.Unlock()
+}
+
+//GetPolicyViolations returns every violation found by the last collation,
+// sorted by group, then deployment
+func (c *Collator) GetPolicyViolations() []PolicyViolation {
+	c.lock.RLock()
+	ret := make([]PolicyViolation, len(c.violations))
+	copy(ret, c.violations)
+	c.lock.RUnlock()
+	return ret
+}
+
+//countViolations returns the number of policy violations in the group. It must
+// be called with the lock held.
+func (c *Collator) countViolations(group *CollationDeploymentGroup) int {
+	ret := 0
+	for _, violation := range c.violations {
+		if violation.Dimension == group.Dimension && violation.Group == group.Name {
+			ret++
+		}
+	}
+
+	return ret
+}
+
+//evaluatePolicies checks every group against the policies, and must be called
+// with the lock held after each collation
+func (c *Collator) evaluatePolicies() {
+	c.violations = nil
+	for i := range c.policies {
+		policy := &c.policies[i]
+		for j := range c.groups {
+			group := &c.groups[j]
+			if !policy.appliesTo(group) {
+				continue
+			}
+
+			for k := range group.Releases {
+				release := &group.Releases[k]
+				if !policy.appliesToRelease(release.Name) {
+					continue
+				}
+
+				for _, version := range release.Versions {
+					required, ok := policy.check(release, version.Version)
+					if ok {
+						continue
+					}
+
+					for _, id := range version.Deployments {
+						c.violations = append(c.violations, PolicyViolation{
+							Policy:       policy.Name,
+							Dimension:    group.Dimension,
+							Group:        group.Name,
+							DeploymentID: id,
+							Release:      release.Name,
+							Version:      version.Version,
+							Required:     required,
+						})
+					}
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(c.violations, func(i, j int) bool {
+		a, b := c.violations[i], c.violations[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.DeploymentID < b.DeploymentID
+	})
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/starkandwayne/signalfire/config"
+)
+
+func TestPolicyViolations(t *testing.T) {
+	policies, err := NewPolicies([]config.Policy{
+		{Name: "uaa-minimum", Group: "prod-*", Release: "uaa", MinVersion: "74.0.0"},
+		{Name: "no-rc-in-prod", Group: "prod-*", ForbidVersion: "rc"},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicies: %s", err)
+	}
+
+	rules, _ := NewCollationRules([]config.CollationRule{
+		{Type: config.CollationRuleTypeDeploymentRegex, Match: `^(\w+-\w+)`},
+	})
+	c := newTestCollator(rules)
+	for _, policy := range policies {
+		c.AddPolicy(policy)
+	}
+
+	c.collateEnvironment(CacheEnvironment{
+		Name: "director",
+		UUID: "uuid",
+		Deployments: CacheDeployments{
+			{Name: "prod-cf-1", Releases: CacheReleases{{Name: "uaa", Version: "73.2.0"}}},
+			{Name: "prod-cf-2", Releases: CacheReleases{{Name: "uaa", Version: "74.1.0"}, {Name: "cf", Version: "12.0.0-rc.1"}}},
+			{Name: "dev-cf-1", Releases: CacheReleases{{Name: "uaa", Version: "70.0.0-rc.1"}}},
+		},
+	})
+
+	got := c.GetPolicyViolations()
+	want := []PolicyViolation{
+		{Policy: "uaa-minimum", Dimension: "group", Group: "prod-cf", DeploymentID: "uuid/prod-cf-1", Release: "uaa", Version: "73.2.0", Required: ">= 74.0.0"},
+		{Policy: "no-rc-in-prod", Dimension: "group", Group: "prod-cf", DeploymentID: "uuid/prod-cf-2", Release: "cf", Version: "12.0.0-rc.1", Required: "not matching rc"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d violations, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Violation %d:\nexpected: %+v\ngot:      %+v", i, want[i], got[i])
+		}
+	}
+
+	for _, group := range c.GetDeploymentGroups() {
+		expected := 0
+		if group.Name == "prod-cf" {
+			expected = 2
+		}
+		if group.PolicyViolations != expected {
+			t.Errorf("Group `%s': expected %d violations, got %d", group.Name, expected, group.PolicyViolations)
+		}
+	}
+}
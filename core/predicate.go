@@ -0,0 +1,251 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"text/template"
+
+	"github.com/starkandwayne/signalfire/config"
+)
+
+//CollationPredicate is a boolean condition which can be checked against a
+// deployment
+type CollationPredicate interface {
+	Matches(CollationDeploymentInput) bool
+}
+
+//NewCollationPredicate validates the given predicate configuration and returns
+// the predicate that it describes. Exactly one of the fields of the
+// configuration must be set.
+func NewCollationPredicate(conf config.CollationPredicate) (CollationPredicate, error) {
+	var ret CollationPredicate
+	numSet := 0
+	set := func(p CollationPredicate) {
+		ret = p
+		numSet++
+	}
+
+	if conf.AllOf != nil {
+		subs, err := newCollationPredicates("all_of", conf.AllOf)
+		if err != nil {
+			return nil, err
+		}
+		set(AllOfPredicate(subs))
+	}
+
+	if conf.AnyOf != nil {
+		subs, err := newCollationPredicates("any_of", conf.AnyOf)
+		if err != nil {
+			return nil, err
+		}
+		set(AnyOfPredicate(subs))
+	}
+
+	if conf.Not != nil {
+		sub, err := NewCollationPredicate(*conf.Not)
+		if err != nil {
+			return nil, fmt.Errorf("not: %s", err)
+		}
+		set(NotPredicate{Predicate: sub})
+	}
+
+	if conf.DeploymentName != "" {
+		match, err := regexp.Compile(conf.DeploymentName)
+		if err != nil {
+			return nil, fmt.Errorf("deployment_name: Could not compile regex: %s", err)
+		}
+		set(DeploymentNamePredicate{Match: match})
+	}
+
+	if conf.DirectorName != "" {
+		match, err := regexp.Compile(conf.DirectorName)
+		if err != nil {
+			return nil, fmt.Errorf("director_name: Could not compile regex: %s", err)
+		}
+		set(DirectorNamePredicate{Match: match})
+	}
+
+	if conf.DirectorUUID != "" {
+		set(DirectorUUIDPredicate{UUID: conf.DirectorUUID})
+	}
+
+	if conf.HasRelease != "" {
+		set(HasReleasePredicate{Release: conf.HasRelease})
+	}
+
+	if conf.ReleaseVersion != nil {
+		if conf.ReleaseVersion.Release == "" {
+			return nil, fmt.Errorf("release_version: A `release' is required")
+		}
+		match, err := regexp.Compile(conf.ReleaseVersion.Match)
+		if err != nil {
+			return nil, fmt.Errorf("release_version: Could not compile regex: %s", err)
+		}
+		set(ReleaseVersionPredicate{Release: conf.ReleaseVersion.Release, Match: match})
+	}
+
+	if numSet == 0 {
+		return nil, fmt.Errorf("Predicate has no condition set")
+	}
+	if numSet > 1 {
+		return nil, fmt.Errorf("Predicate has %d conditions set; combine them with all_of or any_of", numSet)
+	}
+
+	return ret, nil
+}
+
+func newCollationPredicates(key string, confs []config.CollationPredicate) ([]CollationPredicate, error) {
+	if len(confs) == 0 {
+		return nil, fmt.Errorf("%s: At least one predicate is required", key)
+	}
+
+	ret := make([]CollationPredicate, 0, len(confs))
+	for i, conf := range confs {
+		p, err := NewCollationPredicate(conf)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %s", key, i, err)
+		}
+		ret = append(ret, p)
+	}
+
+	return ret, nil
+}
+
+//AllOfPredicate matches if every predicate within it matches
+type AllOfPredicate []CollationPredicate
+
+func (p AllOfPredicate) Matches(in CollationDeploymentInput) bool {
+	for _, sub := range p {
+		if !sub.Matches(in) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//AnyOfPredicate matches if at least one predicate within it matches
+type AnyOfPredicate []CollationPredicate
+
+func (p AnyOfPredicate) Matches(in CollationDeploymentInput) bool {
+	for _, sub := range p {
+		if sub.Matches(in) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//NotPredicate matches if the predicate within it does not match
+type NotPredicate struct {
+	Predicate CollationPredicate
+}
+
+func (p NotPredicate) Matches(in CollationDeploymentInput) bool {
+	return !p.Predicate.Matches(in)
+}
+
+type DeploymentNamePredicate struct {
+	Match *regexp.Regexp
+}
+
+func (p DeploymentNamePredicate) Matches(in CollationDeploymentInput) bool {
+	return p.Match.MatchString(in.DeploymentName)
+}
+
+type DirectorNamePredicate struct {
+	Match *regexp.Regexp
+}
+
+func (p DirectorNamePredicate) Matches(in CollationDeploymentInput) bool {
+	return p.Match.MatchString(in.DirectorName)
+}
+
+type DirectorUUIDPredicate struct {
+	UUID string
+}
+
+func (p DirectorUUIDPredicate) Matches(in CollationDeploymentInput) bool {
+	return p.UUID == in.DirectorUUID
+}
+
+type HasReleasePredicate struct {
+	Release string
+}
+
+func (p HasReleasePredicate) Matches(in CollationDeploymentInput) bool {
+	for _, release := range in.Releases {
+		if release.Name == p.Release {
+			return true
+		}
+	}
+
+	return false
+}
+
+//ReleaseVersionPredicate matches if the deployment has the named release at a
+// version matching the regex
+type ReleaseVersionPredicate struct {
+	Release string
+	Match   *regexp.Regexp
+}
+
+func (p ReleaseVersionPredicate) Matches(in CollationDeploymentInput) bool {
+	for _, release := range in.Releases {
+		if release.Name == p.Release && p.Match.MatchString(release.Version) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//PredicateRule sorts deployments matching Predicate into the group named by
+// executing the Group template against the CollationDeploymentInput
+type PredicateRule struct {
+	Predicate CollationPredicate
+	Group     *template.Template
+}
+
+func newPredicateRule(conf config.CollationRule) (*PredicateRule, error) {
+	if conf.When == nil {
+		return nil, fmt.Errorf("Type `%s' requires a `when' predicate", conf.Type)
+	}
+
+	predicate, err := NewCollationPredicate(*conf.When)
+	if err != nil {
+		return nil, fmt.Errorf("when: %s", err)
+	}
+
+	if conf.Group == "" {
+		return nil, fmt.Errorf("Type `%s' requires a `group'", conf.Type)
+	}
+
+	group, err := template.New("group").Option("missingkey=zero").Parse(conf.Group)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse `group' template: %s", err)
+	}
+
+	return &PredicateRule{Predicate: predicate, Group: group}, nil
+}
+
+func (r *PredicateRule) DeploymentGroup(in CollationDeploymentInput) string {
+	ret, _ := r.deploymentGroup(in)
+	return ret
+}
+
+func (r *PredicateRule) deploymentGroup(in CollationDeploymentInput) (string, error) {
+	if !r.Predicate.Matches(in) {
+		return "", nil
+	}
+
+	buf := bytes.Buffer{}
+	err := r.Group.Execute(&buf, in)
+	if err != nil {
+		return "", fmt.Errorf("Could not execute `group' template: %s", err)
+	}
+
+	return buf.String(), nil
+}
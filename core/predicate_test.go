@@ -0,0 +1,166 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/starkandwayne/signalfire/config"
+	"gopkg.in/yaml.v2"
+)
+
+func parsePredicate(t *testing.T, conf string) CollationPredicate {
+	parsed := config.CollationPredicate{}
+	err := yaml.Unmarshal([]byte(conf), &parsed)
+	if err != nil {
+		t.Fatalf("Could not parse predicate: %s", err)
+	}
+
+	ret, err := NewCollationPredicate(parsed)
+	if err != nil {
+		t.Fatalf("NewCollationPredicate(%s): %s", conf, err)
+	}
+
+	return ret
+}
+
+func TestPredicateMatches(t *testing.T) {
+	in := CollationDeploymentInput{
+		DirectorUUID:   "uuid",
+		DirectorName:   "prod-director",
+		DeploymentName: "cf-prod",
+		Releases:       CacheReleases{{Name: "uaa", Version: "74.1.0"}, {Name: "cf", Version: "12.0.0-rc.1"}},
+	}
+
+	tests := []struct {
+		predicate string
+		matches   bool
+	}{
+		{`deployment_name: ^cf-`, true},
+		{`deployment_name: ^concourse`, false},
+		{`director_name: prod`, true},
+		{`director_uuid: uuid`, true},
+		{`director_uuid: other`, false},
+		{`has_release: uaa`, true},
+		{`has_release: bosh`, false},
+		{`release_version: {release: uaa, match: ^74\.}`, true},
+		{`release_version: {release: uaa, match: ^73\.}`, false},
+		{`release_version: {release: bosh, match: .*}`, false},
+		{`all_of: [{has_release: uaa}, {deployment_name: prod$}]`, true},
+		{`all_of: [{has_release: uaa}, {deployment_name: dev$}]`, false},
+		{`any_of: [{has_release: bosh}, {deployment_name: prod$}]`, true},
+		{`any_of: [{has_release: bosh}, {deployment_name: dev$}]`, false},
+		{`not: {has_release: bosh}`, true},
+		{`not: {any_of: [{has_release: bosh}, {has_release: cf}]}`, false},
+	}
+
+	for _, test := range tests {
+		got := parsePredicate(t, test.predicate).Matches(in)
+		if got != test.matches {
+			t.Errorf("`%s': expected %t, got %t", test.predicate, test.matches, got)
+		}
+	}
+}
+
+func TestNewCollationPredicateRejectsInvalidPredicates(t *testing.T) {
+	tests := []struct {
+		predicate string
+		err       string
+	}{
+		{`{}`, "no condition set"},
+		{`{has_release: uaa, deployment_name: cf}`, "2 conditions set"},
+		{`deployment_name: "("`, "deployment_name"},
+		{`all_of: []`, "all_of: At least one predicate"},
+		{`any_of: [{has_release: uaa}, {}]`, "any_of[1]"},
+		{`not: {director_name: "["}`, "not: director_name"},
+		{`release_version: {match: .*}`, "release"},
+	}
+
+	for _, test := range tests {
+		parsed := config.CollationPredicate{}
+		err := yaml.Unmarshal([]byte(test.predicate), &parsed)
+		if err != nil {
+			t.Fatalf("Could not parse predicate: %s", err)
+		}
+
+		_, err = NewCollationPredicate(parsed)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("`%s': expected an error containing `%s', got %v", test.predicate, test.err, err)
+		}
+	}
+}
+
+func TestPredicateRuleGrouping(t *testing.T) {
+	rules, err := NewCollationRules([]config.CollationRule{
+		{
+			Type:  config.CollationRuleTypePredicate,
+			When:  &config.CollationPredicate{HasRelease: "uaa"},
+			Group: `{{.DirectorName}}-{{index .ManifestTags "env"}}`,
+		},
+		{
+			Type:  config.CollationRuleTypePredicate,
+			When:  &config.CollationPredicate{DeploymentName: "^concourse"},
+			Group: "ci",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewCollationRules: %s", err)
+	}
+
+	c := newTestCollator(rules)
+	c.collateEnvironment(CacheEnvironment{
+		Name: "director",
+		UUID: "uuid",
+		Deployments: CacheDeployments{
+			{Name: "cf", Releases: CacheReleases{{Name: "uaa", Version: "74.1.0"}}, Tags: map[string]string{"env": "prod"}},
+			{Name: "concourse", Releases: CacheReleases{{Name: "concourse", Version: "6.0.0"}}},
+			{Name: "redis", Releases: CacheReleases{{Name: "redis", Version: "15.0.0"}}},
+		},
+	})
+
+	groups := map[string][]string{}
+	for _, group := range c.GetDeploymentGroups() {
+		for _, deployment := range group.Deployments {
+			groups[group.Name] = append(groups[group.Name], deployment.Name)
+		}
+	}
+	if len(groups) != 2 || len(groups["director-prod"]) != 1 || len(groups["ci"]) != 1 {
+		t.Errorf("Unexpected groups %v", groups)
+	}
+
+	ungrouped := c.GetUngroupedDeployments()
+	if len(ungrouped) != 1 || ungrouped[0].Name != "redis" {
+		t.Errorf("Expected only redis to be ungrouped, got %+v", ungrouped)
+	}
+}
+
+func TestPredicateRuleTemplateError(t *testing.T) {
+	rules, err := NewCollationRules([]config.CollationRule{
+		{
+			Type:  config.CollationRuleTypePredicate,
+			When:  &config.CollationPredicate{HasRelease: "uaa"},
+			Group: `{{index .Releases 5}}`,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewCollationRules: %s", err)
+	}
+
+	env := CacheEnvironment{
+		Name: "director",
+		UUID: "uuid",
+		Deployments: CacheDeployments{
+			{Name: "cf", Releases: CacheReleases{{Name: "uaa", Version: "74.1.0"}}},
+		},
+	}
+	result := DryRunCollation([]CacheEnvironment{env}, rules, CollationModeFirstMatch, nil)
+	if len(result.Ungrouped[DefaultCollationDimension]) != 1 {
+		t.Errorf("Expected the deployment to be ungrouped, got %+v", result.Ungrouped)
+	}
+	if len(result.Traces) != 1 || len(result.Traces[0].Errors) != 1 {
+		t.Fatalf("Expected one trace with one error, got %+v", result.Traces)
+	}
+	traceErr := result.Traces[0].Errors[0]
+	if traceErr.RuleIdx != 0 || !strings.Contains(traceErr.Error, "template") {
+		t.Errorf("Unexpected trace error %+v", traceErr)
+	}
+}
@@ -1,7 +1,10 @@
 package core
 
 import (
+	"fmt"
 	"regexp"
+
+	"github.com/starkandwayne/signalfire/config"
 )
 
 //CollationRule sorts deployments pulled from BOSH directors into deployment
@@ -12,6 +15,139 @@ type CollationRule interface {
 	DeploymentGroup(CollationDeploymentInput) string
 }
 
+//DimensionRule sorts deployments into the groups of a tag dimension other
+// than DefaultCollationDimension
+type DimensionRule struct {
+	Dimension string
+	Rule      CollationRule
+}
+
+func (r DimensionRule) DeploymentGroup(in CollationDeploymentInput) string {
+	return r.Rule.DeploymentGroup(in)
+}
+
+func (r DimensionRule) deploymentGroup(in CollationDeploymentInput) (string, error) {
+	return collationRuleGroup(r.Rule, in)
+}
+
+func (r DimensionRule) captures(in CollationDeploymentInput) []string {
+	if c, isCapturing := r.Rule.(capturingRule); isCapturing {
+		return c.captures(in)
+	}
+
+	return nil
+}
+
+//capturingRule is implemented by rules which can report the submatches that
+// they captured from a deployment, to help explain why it was sorted as it was
+type capturingRule interface {
+	captures(CollationDeploymentInput) []string
+}
+
+//erringRule is implemented by rules which can fail to sort a deployment, so
+// that the failure can be reported instead of the deployment silently being
+// left ungrouped
+type erringRule interface {
+	deploymentGroup(CollationDeploymentInput) (string, error)
+}
+
+//collationRuleGroup returns the group that the rule sorts the deployment into,
+// and why it could not, if the rule can fail
+func collationRuleGroup(rule CollationRule, in CollationDeploymentInput) (string, error) {
+	if e, isErring := rule.(erringRule); isErring {
+		return e.deploymentGroup(in)
+	}
+
+	return rule.DeploymentGroup(in), nil
+}
+
+func collationRuleDimension(rule CollationRule) string {
+	if d, isDimensionRule := rule.(DimensionRule); isDimensionRule && d.Dimension != "" {
+		return d.Dimension
+	}
+
+	return DefaultCollationDimension
+}
+
+//NewCollationRules validates the given rule configurations and returns the
+// rules that they describe, in the same order.
+func NewCollationRules(confs []config.CollationRule) ([]CollationRule, error) {
+	ret := make([]CollationRule, 0, len(confs))
+	for i, conf := range confs {
+		rule, err := NewCollationRule(conf)
+		if err != nil {
+			return nil, fmt.Errorf("Collation rule at index %d: %s", i, err)
+		}
+
+		ret = append(ret, rule)
+	}
+
+	return ret, nil
+}
+
+//NewCollationRule validates the given rule configuration and returns the rule
+// that it describes.
+func NewCollationRule(conf config.CollationRule) (rule CollationRule, err error) {
+	switch conf.Type {
+	case config.CollationRuleTypeDeploymentRegex:
+		var match *regexp.Regexp
+		match, err = compileCaptureRegex(conf.Match)
+		rule = DeploymentRegexCaptureRule{Match: match}
+	case config.CollationRuleTypeDirectorRegex:
+		var match *regexp.Regexp
+		match, err = compileCaptureRegex(conf.Match)
+		rule = DirectorRegexCaptureRule{Match: match}
+	case config.CollationRuleTypeNameMap:
+		if len(conf.Map) == 0 {
+			err = fmt.Errorf("Type `%s' requires a non-empty `map'", conf.Type)
+		}
+		rule = DeploymentNameMapRule{Map: conf.Map}
+	case config.CollationRuleTypeRelease:
+		if conf.Release == "" {
+			err = fmt.Errorf("Type `%s' requires a `release'", conf.Type)
+		}
+		rule = ReleasePresenceRule{Release: conf.Release, Group: conf.Group}
+	case config.CollationRuleTypePredicate:
+		rule, err = newPredicateRule(conf)
+	case config.CollationRuleTypeManifestTag:
+		if conf.Key == "" {
+			err = fmt.Errorf("Type `%s' requires a `key'", conf.Type)
+		}
+		rule = ManifestTagRule{Key: conf.Key, Fallback: conf.Fallback}
+	case "":
+		err = fmt.Errorf("No rule type given")
+	default:
+		err = fmt.Errorf("Unknown rule type `%s'", conf.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Dimension != "" && conf.Dimension != DefaultCollationDimension {
+		rule = DimensionRule{Dimension: conf.Dimension, Rule: rule}
+	}
+
+	return
+}
+
+func compileCaptureRegex(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, fmt.Errorf("A `match' regex is required")
+	}
+
+	ret, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("Could not compile `match' regex: %s", err)
+	}
+
+	if ret.NumSubexp() < 1 {
+		return nil, fmt.Errorf("The `match' regex `%s' has no capturing group", expr)
+	}
+
+	return ret, nil
+}
+
 //DeploymentRegexCaptureRule returns the contents of the first
 //capturing group as the deployment name
 type DeploymentRegexCaptureRule struct {
@@ -19,10 +155,81 @@ type DeploymentRegexCaptureRule struct {
 }
 
 func (r DeploymentRegexCaptureRule) DeploymentGroup(in CollationDeploymentInput) string {
-	matches := r.Match.FindStringSubmatch(in.DeploymentName)
+	return firstCapture(r.Match, in.DeploymentName)
+}
+
+func (r DeploymentRegexCaptureRule) captures(in CollationDeploymentInput) []string {
+	return r.Match.FindStringSubmatch(in.DeploymentName)
+}
+
+//DirectorRegexCaptureRule matches against the name of the director that the
+// deployment is on, and returns the contents of the first capturing group as
+// the deployment name
+type DirectorRegexCaptureRule struct {
+	Match *regexp.Regexp
+}
+
+func (r DirectorRegexCaptureRule) DeploymentGroup(in CollationDeploymentInput) string {
+	return firstCapture(r.Match, in.DirectorName)
+}
+
+func (r DirectorRegexCaptureRule) captures(in CollationDeploymentInput) []string {
+	return r.Match.FindStringSubmatch(in.DirectorName)
+}
+
+func firstCapture(match *regexp.Regexp, s string) string {
+	matches := match.FindStringSubmatch(s)
 	if len(matches) < 2 {
 		return ""
 	}
 
 	return matches[1]
 }
+
+//DeploymentNameMapRule looks up the exact deployment name in Map, and returns
+// the value there as the group name
+type DeploymentNameMapRule struct {
+	Map map[string]string
+}
+
+func (r DeploymentNameMapRule) DeploymentGroup(in CollationDeploymentInput) string {
+	return r.Map[in.DeploymentName]
+}
+
+//ReleasePresenceRule sorts any deployment which contains the named release into
+// Group. If Group is empty, the release name is used as the group name.
+type ReleasePresenceRule struct {
+	Release string
+	Group   string
+}
+
+func (r ReleasePresenceRule) DeploymentGroup(in CollationDeploymentInput) string {
+	for _, release := range in.Releases {
+		if release.Name != r.Release {
+			continue
+		}
+
+		if r.Group == "" {
+			return r.Release
+		}
+		return r.Group
+	}
+
+	return ""
+}
+
+//ManifestTagRule uses the value of the manifest tag with the given key as the
+// group name. Deployments without the tag are put in Fallback, unless it is
+// empty.
+type ManifestTagRule struct {
+	Key      string
+	Fallback string
+}
+
+func (r ManifestTagRule) DeploymentGroup(in CollationDeploymentInput) string {
+	if value := in.ManifestTags[r.Key]; value != "" {
+		return value
+	}
+
+	return r.Fallback
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/starkandwayne/signalfire/config"
+)
+
+func TestNewCollationRulesRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule config.CollationRule
+		//err is part of the expected error message
+		err string
+	}{
+		{"no type", config.CollationRule{}, "No rule type given"},
+		{"unknown type", config.CollationRule{Type: "zodiac"}, "Unknown rule type `zodiac'"},
+		{"deployment_regex without match", config.CollationRule{Type: config.CollationRuleTypeDeploymentRegex}, "`match' regex is required"},
+		{"deployment_regex with bad regex", config.CollationRule{Type: config.CollationRuleTypeDeploymentRegex, Match: `(cf`}, "Could not compile `match' regex"},
+		{"deployment_regex without capture", config.CollationRule{Type: config.CollationRuleTypeDeploymentRegex, Match: `cf-.*`}, "no capturing group"},
+		{"director_regex without match", config.CollationRule{Type: config.CollationRuleTypeDirectorRegex}, "`match' regex is required"},
+		{"director_regex with bad regex", config.CollationRule{Type: config.CollationRuleTypeDirectorRegex, Match: `[`}, "Could not compile `match' regex"},
+		{"name_map without map", config.CollationRule{Type: config.CollationRuleTypeNameMap}, "requires a non-empty `map'"},
+		{"release without release", config.CollationRule{Type: config.CollationRuleTypeRelease}, "requires a `release'"},
+		{"predicate without when", config.CollationRule{Type: config.CollationRuleTypePredicate, Group: "cf"}, "requires a `when' predicate"},
+		{"predicate without group", config.CollationRule{
+			Type: config.CollationRuleTypePredicate,
+			When: &config.CollationPredicate{DeploymentName: "cf"},
+		}, "requires a `group'"},
+		{"predicate with bad template", config.CollationRule{
+			Type:  config.CollationRuleTypePredicate,
+			When:  &config.CollationPredicate{DeploymentName: "cf"},
+			Group: "{{.Name",
+		}, "Could not parse `group' template"},
+		{"manifest_tag without key", config.CollationRule{Type: config.CollationRuleTypeManifestTag}, "requires a `key'"},
+	}
+
+	valid := config.CollationRule{Type: config.CollationRuleTypeDeploymentRegex, Match: `(.*)`}
+	for _, test := range tests {
+		//The bad rule comes after a valid one, so that its index is not zero
+		_, err := NewCollationRules([]config.CollationRule{valid, test.rule})
+		if err == nil {
+			t.Errorf("%s: Expected an error", test.name)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), "Collation rule at index 1: ") {
+			t.Errorf("%s: Expected the error to name index 1, got `%s'", test.name, err)
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: Expected the error to contain `%s', got `%s'", test.name, test.err, err)
+		}
+	}
+
+	rules, err := NewCollationRules([]config.CollationRule{valid, {Type: config.CollationRuleTypeRelease, Release: "cf"}})
+	if err != nil || len(rules) != 2 {
+		t.Errorf("Expected valid rules to be accepted, got %v, %s", rules, err)
+	}
+}
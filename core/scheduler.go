@@ -1,52 +1,403 @@
 package core
 
 import (
-	"github.com/starkandwayne/signalfire/log"
+	"sort"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/starkandwayne/signalfire/bosh"
+	"github.com/starkandwayne/signalfire/log"
+)
+
+//taskHistoryLength is how many of the most recent tasks of each deployment are
+// looked through for its task history
+const taskHistoryLength = 30
+
+//configHistoryLength is how many of the most recent versions of each type of
+// config are looked through for the two most recent versions of each config
+const configHistoryLength = 100
+
+//Bounds of the backoff between attempts to connect to a director
+const (
+	minConnectBackoff = 5 * time.Second
+	maxConnectBackoff = 5 * time.Minute
 )
 
+//configTypes are the types of director config which are tracked
+var configTypes = []string{bosh.ConfigTypeCloud, bosh.ConfigTypeRuntime, bosh.ConfigTypeCPI}
+
+//Scheduler polls directors into the cache. It must be made with NewScheduler.
 type Scheduler struct {
 	Boshes []BOSH
 	Cache  *Cache
 	Logger *log.Logger
+	//stop is closed when the scheduler is stopped
+	stop     chan struct{}
+	stopOnce sync.Once
+	running  sync.WaitGroup
+}
+
+func NewScheduler(boshes []BOSH, cache *Cache, logger *log.Logger) *Scheduler {
+	return &Scheduler{
+		Boshes: boshes,
+		Cache:  cache,
+		Logger: logger,
+		stop:   make(chan struct{}),
+	}
 }
 
+//Start connects to every director, retrying those which cannot be reached, and
+// polls each once connected, until the scheduler is stopped
 func (s *Scheduler) Start() {
-	//This is pretty garbage, but it's a start
 	for _, b := range s.Boshes {
+		s.running.Add(1)
 		go func(thisBOSH BOSH) {
-			s.scrapeBOSH(thisBOSH)
-			for range time.Tick(thisBOSH.PollInterval) {
-				s.scrapeBOSH(thisBOSH)
+			defer s.running.Done()
+			if !s.connect(thisBOSH) {
+				return
 			}
+
+			if thisBOSH.InstancesPollInterval > 0 {
+				s.running.Add(1)
+				go func() {
+					defer s.running.Done()
+					s.every(thisBOSH.InstancesPollInterval, func() { s.scrapeInstances(thisBOSH) })
+				}()
+			}
+
+			s.every(thisBOSH.PollInterval, func() { s.scrapeBOSH(thisBOSH) })
 		}(b)
 	}
 }
 
+//Stop stops polling the directors, waiting for any scrapes in progress to
+// finish, and closes their clients. It is safe to call more than once, and
+// whether or not the scheduler was started.
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.running.Wait()
+		for _, b := range s.Boshes {
+			b.Client.Close()
+		}
+	})
+}
+
+//every calls f now and then on every interval, until the scheduler is stopped.
+// If the interval is not positive, f is only called once.
+func (s *Scheduler) every(interval time.Duration, f func()) {
+	f()
+	if interval <= 0 {
+		<-s.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			f()
+		}
+	}
+}
+
+//connect connects to the director, retrying with exponential backoff until it
+// succeeds. Until then, the director is in the cache as unreachable. It returns
+// false if the scheduler was stopped first.
+func (s *Scheduler) connect(b BOSH) bool {
+	backoff := minConnectBackoff
+	failures := 0
+	for {
+		attemptedAt := time.Now()
+		err := b.Client.Connect()
+		if err == nil {
+			return true
+		}
+
+		failures++
+		s.Logger.Error("Could not connect to BOSH at `%s', so retrying in %s: %s", b.Client.URL(), backoff, err)
+		s.Cache.UpdateEnvironment(CacheEnvironment{
+			URL: b.Client.URL(),
+			Scrape: CacheScrapeStatus{
+				Status:              DirectorStatusUnreachable,
+				LastAttemptAt:       attemptedAt,
+				LastError:           err.Error(),
+				ConsecutiveFailures: failures,
+			},
+		})
+
+		select {
+		case <-s.stop:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
 func (s *Scheduler) scrapeBOSH(b BOSH) {
+	attemptedAt := time.Now()
+	previous, _ := s.Cache.GetEnvironment(b.Client.UUID())
 	deps, err := b.Client.Deployments()
 	if err != nil {
 		s.Logger.Error("Could not get deployments from BOSH with name `%s': %s", b.Client.Name(), err)
+		//Keep what was last known of the director, rather than leaving it with no
+		// deployments
+		failed := previous
+		failed.Name, failed.UUID, failed.URL = b.Client.Name(), b.Client.UUID(), b.Client.URL()
+		failed.Scrape.Status = DirectorStatusUnreachable
+		failed.Scrape.LastAttemptAt = attemptedAt
+		failed.Scrape.LastError = err.Error()
+		failed.Scrape.ConsecutiveFailures++
+		s.Cache.UpdateEnvironment(failed)
+		return
 	}
+
 	toPush := CacheEnvironment{
 		Name: b.Client.Name(),
 		UUID: b.Client.UUID(),
+		URL:  b.Client.URL(),
+		Scrape: CacheScrapeStatus{
+			Status:        DirectorStatusOK,
+			LastSuccessAt: attemptedAt,
+			LastAttemptAt: attemptedAt,
+		},
 	}
+	previousDeps := map[string]CacheDeployment{}
+	for _, dep := range previous.Deployments {
+		previousDeps[dep.Name] = dep
+	}
+
+	info, err := b.Client.Info()
+	if err != nil {
+		s.Logger.Error("Could not get info from BOSH with name `%s': %s", b.Client.Name(), err)
+		//Keep what was last known about the director
+		toPush.Director = previous.Director
+	} else {
+		toPush.Director = CacheDirector{
+			Version:  info.Version,
+			CPI:      info.CPI,
+			Features: info.Features,
+		}
+	}
+
+	configs, err := s.scrapeConfigs(b)
+	if err != nil {
+		s.Logger.Error("Could not get configs from BOSH with name `%s': %s", b.Client.Name(), err)
+		//Keep what was last known of the configs
+		configs = previous.Director.Configs
+	}
+	toPush.Director.Configs = configs
+	addons := configs.addonReleases()
+
 	for _, dep := range deps {
-		depToPush := CacheDeployment{
-			Name: dep.Name,
+		prevDep := previousDeps[dep.Name]
+		//Instances are fetched on their own interval, and the cache keeps them
+		depToPush := CacheDeployment{Name: dep.Name}
+
+		//inManifest is nil if the manifest could not be read, as then which
+		// releases are add-ons is not known
+		var inManifest map[string]bool
+		manifest, err := b.Client.Manifest(dep.Name)
+		if err != nil {
+			s.Logger.Error("Could not get manifest from BOSH with name `%s': %s", b.Client.Name(), err)
+			//Keep what was last known of the manifest, so that the deployment stays
+			// in the groups given by its tags
+			depToPush.Tags = prevDep.Tags
+			depToPush.Manifest = prevDep.Manifest
+		} else {
+			inManifest = map[string]bool{}
+			depToPush.Tags = manifest.Tags
+			for _, name := range manifest.Releases {
+				inManifest[name] = true
+			}
+			depToPush.Manifest, err = RedactYAML(manifest.Raw)
+			if err != nil {
+				s.Logger.Error("Could not redact manifest of deployment `%s' from BOSH with name `%s': %s", dep.Name, b.Client.Name(), err)
+			}
+		}
+
+		//If the tasks could not be fetched, this keeps what was last known of the
+		// task history
+		tasks, err := b.Client.Tasks(dep.Name, taskHistoryLength)
+		if err != nil {
+			s.Logger.Error("Could not get tasks from BOSH with name `%s': %s", b.Client.Name(), err)
 		}
+		depToPush.Tasks = newCacheTaskHistory(tasks, prevDep.Tasks)
 
+		prevAddons := map[string]string{}
+		for _, rel := range prevDep.Releases {
+			prevAddons[rel.Name] = rel.RuntimeConfig
+		}
 		for _, rel := range dep.Releases {
 			relToPush := CacheRelease{
 				Name:    rel.Name,
 				Version: rel.Version,
 			}
+			switch {
+			case inManifest == nil:
+				//Keep what was last known of whether the release is an add-on
+				relToPush.RuntimeConfig = prevAddons[rel.Name]
+			case !inManifest[rel.Name]:
+				relToPush.RuntimeConfig = addons[rel.Name]
+			}
 
 			depToPush.Releases = append(depToPush.Releases, relToPush)
 		}
 
+		for _, stemcell := range dep.Stemcells {
+			depToPush.Stemcells = append(depToPush.Stemcells, CacheStemcell{
+				Name:    stemcell.Name,
+				OS:      stemcell.OS(),
+				Version: stemcell.Version,
+			})
+		}
+
 		toPush.Deployments = append(toPush.Deployments, depToPush)
 	}
 	s.Cache.UpdateEnvironment(toPush)
 }
+
+//scrapeConfigs fetches the recent versions of every tracked type of config of
+// the director
+func (s *Scheduler) scrapeConfigs(b BOSH) (CacheConfigs, error) {
+	all := []bosh.Config{}
+	for _, configType := range configTypes {
+		configs, err := b.Client.Configs(configType, configHistoryLength)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, configs...)
+	}
+
+	return newCacheConfigs(all, s.Logger), nil
+}
+
+//newCacheConfigs keeps the two most recent versions of each config, redacting
+// their contents
+func newCacheConfigs(configs []bosh.Config, logger *log.Logger) CacheConfigs {
+	sort.SliceStable(configs, func(i, j int) bool {
+		a, b := configs[i], configs[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		//IDs are assigned in increasing order
+		return compareNumericStrings(a.ID, b.ID) > 0
+	})
+
+	ret := CacheConfigs{}
+	for i, config := range configs {
+		if i >= 2 && configs[i-2].Type == config.Type && configs[i-2].Name == config.Name {
+			continue
+		}
+
+		toAdd := CacheConfig{
+			Type:      config.Type,
+			Name:      config.Name,
+			ID:        config.ID,
+			CreatedAt: config.CreatedAt,
+		}
+		var err error
+		toAdd.Content, err = RedactYAML(config.Content)
+		if err != nil {
+			logger.Error("Could not redact %s config `%s': %s", config.Type, config.Name, err)
+			toAdd.Unparsable = true
+		}
+		ret = append(ret, toAdd)
+	}
+
+	return ret
+}
+
+//scrapeInstances fetches the instances of every deployment of the director
+// that is in the cache
+func (s *Scheduler) scrapeInstances(b BOSH) {
+	env, found := s.Cache.GetEnvironment(b.Client.UUID())
+	if !found {
+		return
+	}
+
+	for _, dep := range env.Deployments {
+		instances, err := b.Client.Instances(dep.Name)
+		if err != nil {
+			s.Logger.Error("Could not get instances from BOSH with name `%s': %s", b.Client.Name(), err)
+			continue
+		}
+
+		toPush := make(CacheInstances, 0, len(instances))
+		for _, instance := range instances {
+			toPush = append(toPush, CacheInstance{
+				InstanceGroup: instance.InstanceGroup,
+				Index:         instance.Index,
+				ID:            instance.ID,
+				AZ:            instance.AZ,
+				ProcessState:  instance.ProcessState,
+				VMType:        instance.VMType,
+				VMCID:         instance.VMCID,
+				IPs:           instance.IPs,
+			})
+		}
+		s.Cache.UpdateInstances(env.UUID, dep.Name, toPush)
+	}
+}
+
+//newCacheTaskHistory summarizes tasks given newest first. Whatever the tasks do
+// not tell, such as the last deploy of a deployment which has not been deployed
+// recently, is kept from the previous history.
+func newCacheTaskHistory(tasks []bosh.Task, previous CacheTaskHistory) CacheTaskHistory {
+	ret := CacheTaskHistory{}
+	sawDeploy := false
+	for _, task := range tasks {
+		if !task.Done() {
+			continue
+		}
+
+		isDeploy := strings.HasPrefix(task.Description, "create deployment") &&
+			!strings.Contains(task.Description, "dry run")
+		if isDeploy && !sawDeploy {
+			sawDeploy = true
+			ret.LastDeployFailed = task.Failed()
+		}
+		if isDeploy && !task.Failed() && ret.LastDeploy == nil {
+			ret.LastDeploy = newCacheTask(task)
+		}
+		if task.Failed() && ret.LastFailed == nil {
+			ret.LastFailed = newCacheTask(task)
+		}
+	}
+
+	if !sawDeploy {
+		ret.LastDeployFailed = previous.LastDeployFailed
+	}
+	if ret.LastDeploy == nil {
+		ret.LastDeploy = previous.LastDeploy
+	}
+	if ret.LastFailed == nil {
+		ret.LastFailed = previous.LastFailed
+	}
+
+	return ret
+}
+
+func newCacheTask(task bosh.Task) *CacheTask {
+	return &CacheTask{
+		ID:          task.ID,
+		State:       task.State,
+		Description: task.Description,
+		User:        task.User,
+		Result:      task.Result,
+		//The timestamp of a finished task is when it finished
+		FinishedAt: time.Unix(task.Timestamp, 0).UTC(),
+	}
+}
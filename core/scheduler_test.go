@@ -0,0 +1,292 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/starkandwayne/signalfire/bosh"
+	"github.com/starkandwayne/signalfire/bosh/boshtest"
+)
+
+func TestNewCacheTaskHistory(t *testing.T) {
+	//Tasks are listed newest first
+	tasks := []bosh.Task{
+		{ID: 9, State: "processing", Description: "create deployment"},
+		{ID: 8, State: "done", Description: "create deployment (dry run)", Timestamp: 800},
+		{ID: 7, State: "error", Description: "create deployment", User: "ci", Timestamp: 700},
+		{ID: 6, State: "error", Description: "run errand smoke-tests", Timestamp: 600},
+		{ID: 5, State: "done", Description: "create deployment", User: "admin", Timestamp: 500},
+		{ID: 4, State: "done", Description: "create deployment", Timestamp: 400},
+	}
+
+	history := newCacheTaskHistory(tasks, CacheTaskHistory{})
+	if !history.LastDeployFailed {
+		t.Errorf("Expected the last deploy to have failed")
+	}
+	if history.LastDeploy == nil || history.LastDeploy.ID != 5 {
+		t.Fatalf("Expected last deploy to be task 5, got %+v", history.LastDeploy)
+	}
+	if history.LastDeploy.User != "admin" || !history.LastDeploy.FinishedAt.Equal(time.Unix(500, 0)) {
+		t.Errorf("Unexpected last deploy %+v", history.LastDeploy)
+	}
+	if history.LastFailed == nil || history.LastFailed.ID != 7 {
+		t.Errorf("Expected last failed task to be task 7, got %+v", history.LastFailed)
+	}
+
+	history = newCacheTaskHistory(tasks[3:], CacheTaskHistory{})
+	if history.LastDeployFailed {
+		t.Errorf("Expected the last deploy to have succeeded")
+	}
+	if history.LastFailed == nil || history.LastFailed.ID != 6 {
+		t.Errorf("Expected last failed task to be task 6, got %+v", history.LastFailed)
+	}
+
+	//Deploys which time out or are cancelled fail too
+	for _, state := range []string{"timeout", "cancelled"} {
+		history = newCacheTaskHistory([]bosh.Task{
+			{ID: 11, State: state, Description: "create deployment", Timestamp: 1100},
+			{ID: 10, State: "done", Description: "create deployment", Timestamp: 1000},
+		}, CacheTaskHistory{})
+		if !history.LastDeployFailed {
+			t.Errorf("%s: Expected the last deploy to have failed", state)
+		}
+		if history.LastFailed == nil || history.LastFailed.ID != 11 {
+			t.Errorf("%s: Expected last failed task to be task 11, got %+v", state, history.LastFailed)
+		}
+		if history.LastDeploy == nil || history.LastDeploy.ID != 10 {
+			t.Errorf("%s: Expected last deploy to be task 10, got %+v", state, history.LastDeploy)
+		}
+	}
+
+	history = newCacheTaskHistory(nil, CacheTaskHistory{})
+	if history.LastDeploy != nil || history.LastFailed != nil || history.LastDeployFailed {
+		t.Errorf("Expected an empty history, got %+v", history)
+	}
+}
+
+func TestNewCacheConfigs(t *testing.T) {
+	configs := []bosh.Config{
+		{ID: "3", Type: "cloud", Name: "default", Content: "azs: [{name: z1}]"},
+		{ID: "12", Type: "runtime", Name: "dns", Content: "releases: [{name: bosh-dns, version: '1.17.0'}]"},
+		{ID: "10", Type: "cloud", Name: "default", Content: "azs: [{name: z1}, {name: z2}]"},
+		{ID: "9", Type: "runtime", Name: "dns", Content: "releases: [{name: bosh-dns, version: '1.16.0'}]"},
+		{ID: "1", Type: "cloud", Name: "default", Content: "azs: []"},
+		{ID: "4", Type: "cpi", Name: "default", Content: "cpis: [{name: aws, properties: {secret_access_key: hunter2}}, {name: gcp, properties: {json_key: gcpkey1, api_key: apikey1}}]"},
+	}
+
+	cached := newCacheConfigs(configs, testLogger())
+	ids := []string{}
+	for _, config := range cached {
+		ids = append(ids, config.ID)
+	}
+	if strings.Join(ids, ",") != "10,3,4,12,9" {
+		t.Fatalf("Expected configs 10,3,4,12,9, got %s", strings.Join(ids, ","))
+	}
+	for _, secret := range []string{"hunter2", "gcpkey1", "apikey1"} {
+		if strings.Contains(cached[2].Content, secret) {
+			t.Errorf("Expected `%s' to be redacted from the CPI config, got %s", secret, cached[2].Content)
+		}
+	}
+
+	latest := cached.Latest()
+	if len(latest) != 3 || latest[0].ID != "10" || latest[2].ID != "12" {
+		t.Errorf("Unexpected latest configs %+v", latest)
+	}
+
+	previous, found := cached.Previous(latest[0])
+	if !found || previous.ID != "3" {
+		t.Errorf("Expected config 3 before config 10, got %+v", previous)
+	}
+	if _, found := cached.Previous(latest[1]); found {
+		t.Errorf("Expected no config before config 4")
+	}
+
+	addons := cached.addonReleases()
+	if len(addons) != 1 || addons["bosh-dns"] != "dns" {
+		t.Errorf("Expected bosh-dns to be added by runtime config dns, got %v", addons)
+	}
+}
+
+func newTestScheduler(t *testing.T, director *boshtest.Director) (*Scheduler, BOSH) {
+	logger := testLogger()
+	client, err := bosh.NewClient(director.Config(), logger)
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	err = client.Connect()
+	if err != nil {
+		t.Fatalf("Could not connect: %s", err)
+	}
+
+	b := BOSH{Client: client, PollInterval: time.Hour}
+	return NewScheduler([]BOSH{b}, NewCache(), logger), b
+}
+
+func TestScrapeKeepsManifestWhenFetchFails(t *testing.T) {
+	director := boshtest.NewDirector("director", "uuid")
+	defer director.Close()
+	director.AddDeployment(boshtest.Deployment{
+		Name:     "cf",
+		Releases: []bosh.Release{{Name: "uaa", Version: "74.0.0"}},
+		Tags:     map[string]string{"env": "prod"},
+	})
+
+	s, b := newTestScheduler(t, director)
+	defer b.Client.Close()
+	s.scrapeBOSH(b)
+
+	director.InjectFault("/deployments/cf", boshtest.Fault{Status: 500})
+	s.scrapeBOSH(b)
+
+	env, _ := s.Cache.GetEnvironment("uuid")
+	if len(env.Deployments) != 1 {
+		t.Fatalf("Expected one deployment, got %+v", env.Deployments)
+	}
+	dep := env.Deployments[0]
+	if dep.Tags["env"] != "prod" {
+		t.Errorf("Expected the tags to be kept, got %v", dep.Tags)
+	}
+	if !strings.Contains(dep.Manifest, "uaa") {
+		t.Errorf("Expected the manifest to be kept, got %q", dep.Manifest)
+	}
+}
+
+func TestNewCacheTaskHistoryKeepsPrevious(t *testing.T) {
+	previous := newCacheTaskHistory([]bosh.Task{
+		{ID: 2, State: "error", Description: "create deployment", Timestamp: 200},
+		{ID: 1, State: "done", Description: "create deployment", Timestamp: 100},
+	}, CacheTaskHistory{})
+
+	//Only an errand has run since
+	history := newCacheTaskHistory([]bosh.Task{
+		{ID: 3, State: "done", Description: "run errand smoke-tests", Timestamp: 300},
+	}, previous)
+	if !history.LastDeployFailed {
+		t.Errorf("Expected the last deploy to still have failed")
+	}
+	if history.LastDeploy == nil || history.LastDeploy.ID != 1 {
+		t.Errorf("Expected last deploy to be task 1, got %+v", history.LastDeploy)
+	}
+	if history.LastFailed == nil || history.LastFailed.ID != 2 {
+		t.Errorf("Expected last failed task to be task 2, got %+v", history.LastFailed)
+	}
+
+	//A newer deploy replaces what was kept
+	history = newCacheTaskHistory([]bosh.Task{
+		{ID: 4, State: "done", Description: "create deployment", Timestamp: 400},
+	}, previous)
+	if history.LastDeployFailed || history.LastDeploy == nil || history.LastDeploy.ID != 4 {
+		t.Errorf("Expected a successful last deploy 4, got %+v", history)
+	}
+}
+
+func TestScrapeFetchesTasksOfEachDeployment(t *testing.T) {
+	director := boshtest.NewDirector("director", "uuid")
+	defer director.Close()
+	for _, name := range []string{"cf", "redis", "concourse"} {
+		director.AddDeployment(boshtest.Deployment{Name: name})
+	}
+	director.AddTask("cf", bosh.Task{State: "done", Description: "create deployment"})
+	director.AddTask("redis", bosh.Task{State: "error", Description: "create deployment"})
+	//A busy deployment must not push the tasks of quiet ones out of the history
+	for i := 0; i < 2*taskHistoryLength; i++ {
+		director.AddTask("concourse", bosh.Task{State: "done", Description: "run errand smoke-tests"})
+	}
+
+	s, b := newTestScheduler(t, director)
+	defer b.Client.Close()
+	s.scrapeBOSH(b)
+
+	if requests := director.Requests("/tasks"); requests != 3 {
+		t.Errorf("Expected a request for tasks per deployment, got %d", requests)
+	}
+
+	env, _ := s.Cache.GetEnvironment("uuid")
+	failed := map[string]bool{}
+	for _, dep := range env.Deployments {
+		failed[dep.Name] = dep.Tasks.LastDeployFailed
+		if (dep.Tasks.LastDeploy != nil) != (dep.Name == "cf") {
+			t.Errorf("%s: Unexpected last deploy %+v", dep.Name, dep.Tasks.LastDeploy)
+		}
+	}
+	if failed["cf"] || !failed["redis"] || failed["concourse"] {
+		t.Errorf("Unexpected failed deploys %v", failed)
+	}
+}
+
+func TestScrapeKeepsAddonsWhenManifestFetchFails(t *testing.T) {
+	director := boshtest.NewDirector("director", "uuid")
+	defer director.Close()
+	director.AddDeployment(boshtest.Deployment{
+		Name:     "cf",
+		Releases: []bosh.Release{{Name: "uaa", Version: "74.0.0"}, {Name: "bosh-dns", Version: "1.17.0"}},
+		Manifest: "releases: [{name: uaa, version: 74.0.0}]",
+	})
+	director.AddConfig(bosh.ConfigTypeRuntime, "dns", "releases: [{name: bosh-dns, version: 1.17.0}]")
+
+	s, b := newTestScheduler(t, director)
+	defer b.Client.Close()
+	s.scrapeBOSH(b)
+
+	//A runtime config which also lists a release of the manifest must not make
+	// it an add-on while the manifest cannot be read
+	director.AddConfig(bosh.ConfigTypeRuntime, "everything", "releases: [{name: uaa, version: 74.0.0}]")
+	director.InjectFault("/deployments/cf", boshtest.Fault{Status: 500})
+	s.scrapeBOSH(b)
+
+	env, _ := s.Cache.GetEnvironment("uuid")
+	addons := map[string]string{}
+	for _, release := range env.Deployments[0].Releases {
+		addons[release.Name] = release.RuntimeConfig
+	}
+	if addons["uaa"] != "" || addons["bosh-dns"] != "dns" {
+		t.Errorf("Expected only bosh-dns to be an add-on, got %v", addons)
+	}
+}
+
+func TestNewCacheConfigsMarksUnparsable(t *testing.T) {
+	cached := newCacheConfigs([]bosh.Config{
+		{ID: "2", Type: "cloud", Name: "default", Content: "azs: [{name: z1"},
+		{ID: "1", Type: "cloud", Name: "default", Content: "azs: []"},
+	}, testLogger())
+
+	if len(cached) != 2 || !cached[0].Unparsable || cached[1].Unparsable {
+		t.Errorf("Expected only config 2 to be unparsable, got %+v", cached)
+	}
+}
+
+func TestSchedulerStopEndsPolling(t *testing.T) {
+	director := boshtest.NewDirector("director", "uuid")
+	defer director.Close()
+	director.AddDeployment(boshtest.Deployment{Name: "cf"})
+
+	s, _ := newTestScheduler(t, director)
+	s.Boshes[0].PollInterval = 10 * time.Millisecond
+	s.Start()
+	for i := 0; director.Requests("/deployments") < 2; i++ {
+		if i == 100 {
+			t.Fatalf("Expected the director to be polled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Stop()
+	polled := director.Requests("/deployments")
+	time.Sleep(50 * time.Millisecond)
+	if after := director.Requests("/deployments"); after != polled {
+		t.Errorf("Expected polling to end on stop, but %d more requests were made", after-polled)
+	}
+
+	//Stopping again does nothing
+	s.Stop()
+}
+
+func TestSchedulerStopsWithoutStarting(t *testing.T) {
+	director := boshtest.NewDirector("director", "uuid")
+	defer director.Close()
+
+	s, _ := newTestScheduler(t, director)
+	s.Stop()
+	s.Stop()
+}
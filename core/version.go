@@ -0,0 +1,383 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/starkandwayne/signalfire/config"
+)
+
+//VersionScheme orders the version strings of a release
+type VersionScheme interface {
+	//Compare returns
+	// < 0 if v1 < v2
+	// 0   if v1 == v2
+	// > 0 if v1 > v2
+	Compare(v1, v2 string) int
+	//Parses returns false for versions which the scheme cannot order, such as
+	// commit SHA builds. Such versions are incomparable: Compare only places
+	// them before all others so that listings are sorted deterministically, and
+	// they are left out of drift, policy, advisory, catalog, and pipeline
+	// checks.
+	Parses(version string) bool
+}
+
+//NewVersionScheme returns the VersionScheme with the given configuration name
+func NewVersionScheme(name string) (scheme VersionScheme, err error) {
+	switch name {
+	case config.VersionSchemeLegacy, "":
+		scheme = LegacyVersionScheme{}
+	case config.VersionSchemeSemver:
+		scheme = SemverVersionScheme{}
+	case config.VersionSchemeBOSHDev:
+		scheme = BOSHDevVersionScheme{}
+	case config.VersionSchemeCalver:
+		scheme = CalverVersionScheme{}
+	default:
+		err = fmt.Errorf("Unknown version scheme `%s'", name)
+	}
+
+	return
+}
+
+//VersionSchemes picks the VersionScheme to use for each release
+type VersionSchemes struct {
+	Default   VersionScheme
+	ByRelease map[string]VersionScheme
+	//Stemcell orders the versions of stemcells
+	Stemcell VersionScheme
+}
+
+//NewVersionSchemes validates the given configuration and returns the version
+// schemes that it describes
+func NewVersionSchemes(conf config.Versions) (*VersionSchemes, error) {
+	def, err := NewVersionScheme(conf.DefaultScheme)
+	if err != nil {
+		return nil, fmt.Errorf("default_scheme: %s", err)
+	}
+
+	stemcell, err := NewVersionScheme(conf.StemcellScheme)
+	if err != nil {
+		return nil, fmt.Errorf("stemcell_scheme: %s", err)
+	}
+
+	ret := &VersionSchemes{
+		Default:   def,
+		ByRelease: map[string]VersionScheme{},
+		Stemcell:  stemcell,
+	}
+	for release, name := range conf.Schemes {
+		ret.ByRelease[release], err = NewVersionScheme(name)
+		if err != nil {
+			return nil, fmt.Errorf("Scheme for release `%s': %s", release, err)
+		}
+	}
+
+	return ret, nil
+}
+
+//For returns the VersionScheme for the named release. It is safe to call on a
+// nil VersionSchemes, which orders every release with the LegacyVersionScheme.
+func (s *VersionSchemes) For(release string) VersionScheme {
+	if s == nil {
+		return LegacyVersionScheme{}
+	}
+
+	if scheme, found := s.ByRelease[release]; found {
+		return scheme
+	}
+
+	if s.Default == nil {
+		return LegacyVersionScheme{}
+	}
+	return s.Default
+}
+
+//ForStemcells returns the VersionScheme for stemcells. It is safe to call on a
+// nil VersionSchemes, which orders stemcells with the LegacyVersionScheme.
+func (s *VersionSchemes) ForStemcells() VersionScheme {
+	if s == nil || s.Stemcell == nil {
+		return LegacyVersionScheme{}
+	}
+
+	return s.Stemcell
+}
+
+//LegacyVersionScheme compares every run of digits in the version as a number,
+// and treats anything after "rc" as a release candidate number which comes
+// before the version without it.
+type LegacyVersionScheme struct{}
+
+//Parses returns true for every version, as the legacy scheme orders anything
+// by its runs of digits
+func (LegacyVersionScheme) Parses(string) bool { return true }
+
+func (s LegacyVersionScheme) Compare(v1, v2 string) int {
+	n1, rc1 := s.parseVersionAndRC(v1)
+	n2, rc2 := s.parseVersionAndRC(v2)
+	vDiff := versionNumDiff(n1, n2)
+	if vDiff == 0 {
+		//A non-rc is greater than an rc
+		if len(rc1) == 0 && len(rc2) == 0 {
+			return 0
+		}
+		if len(rc1) == 0 {
+			return 1
+		}
+		if len(rc2) == 0 {
+			return -1
+		}
+		vDiff = versionNumDiff(rc1, rc2)
+	}
+	return signOf(vDiff)
+}
+
+var (
+	legacyRCRegex     = regexp.MustCompile("rc[^0-9]*(.*)$")
+	nonDigitsRegex    = regexp.MustCompile("[^0-9]+")
+	semverRegex       = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?$`)
+	boshDevRegex      = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)*)(?:\+dev\.([0-9]+))?$`)
+	calverRegex       = regexp.MustCompile(`^v?([0-9]+(?:[.\-_][0-9]+)*)(?:[-+.]?([A-Za-z][0-9A-Za-z.\-]*))?$`)
+	digitsOnlyRegex   = regexp.MustCompile(`^[0-9]+$`)
+	calverSplitRegex  = regexp.MustCompile(`[.\-_]`)
+	identifierSplitRe = regexp.MustCompile(`\.`)
+)
+
+func (LegacyVersionScheme) parseVersionAndRC(v string) (version []int64, rc []int64) {
+	matches := legacyRCRegex.FindStringSubmatch(v)
+	if len(matches) > 0 {
+		v = strings.Replace(v, matches[0], "", -1)
+		rc = []int64{0}
+		if len(matches) > 1 {
+			rc = parseVersionString(matches[1])
+		}
+	}
+	version = parseVersionString(v)
+	return
+}
+
+func parseVersionString(version string) []int64 {
+	numberedComponents := nonDigitsRegex.Split(version, -1)
+	if len(numberedComponents) == 0 {
+		return []int64{0}
+	}
+	ret := make([]int64, len(numberedComponents))
+	for i, numStr := range numberedComponents {
+		ret[i], _ = strconv.ParseInt(numStr, 10, 64)
+	}
+
+	return ret
+}
+
+// versionNumDiff returns
+// < 0 if v1 < v2
+// 0   if v1 == v2
+// > 0 if v1 > v2
+func versionNumDiff(v1, v2 []int64) int64 {
+	maxLen := len(v1)
+	if len(v2) > maxLen {
+		maxLen = len(v2)
+	}
+
+	for i := 0; i < maxLen; i++ {
+		//Default the point to 0, in the case that both versions don't have the
+		// same number of points
+		var v1Val, v2Val int64 = 0, 0
+		if len(v1) > i {
+			v1Val = v1[i]
+		}
+		if len(v2) > i {
+			v2Val = v2[i]
+		}
+		if v1Val != v2Val {
+			return v1Val - v2Val
+		}
+	}
+
+	return 0
+}
+
+func signOf(n int64) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+//compareUnparseable places versions which a scheme could not parse before all
+// versions which could be parsed, and orders them by string among themselves.
+// This only keeps sorting deterministic, as such versions are incomparable.
+func compareUnparseable(v1, v2 string, ok1, ok2 bool) (int, bool) {
+	switch {
+	case ok1 && ok2:
+		return 0, false
+	case ok1:
+		return 1, true
+	case ok2:
+		return -1, true
+	}
+	return strings.Compare(v1, v2), true
+}
+
+//SemverVersionScheme orders versions by the precedence rules of Semantic
+// Versioning 2.0.0. A leading "v" is permitted. Build metadata does not affect
+// precedence, but is compared as a string to break ties.
+type SemverVersionScheme struct{}
+
+func (SemverVersionScheme) Parses(version string) bool {
+	return semverRegex.MatchString(version)
+}
+
+func (SemverVersionScheme) Compare(v1, v2 string) int {
+	m1 := semverRegex.FindStringSubmatch(v1)
+	m2 := semverRegex.FindStringSubmatch(v2)
+	if ret, done := compareUnparseable(v1, v2, m1 != nil, m2 != nil); done {
+		return ret
+	}
+
+	for i := 1; i <= 3; i++ {
+		if diff := compareNumericStrings(m1[i], m2[i]); diff != 0 {
+			return diff
+		}
+	}
+
+	if diff := comparePrerelease(m1[4], m2[4]); diff != 0 {
+		return diff
+	}
+
+	return strings.Compare(m1[5], m2[5])
+}
+
+//comparePrerelease compares dot-separated pre-release identifiers. A version
+// without a pre-release has higher precedence than one with.
+func comparePrerelease(p1, p2 string) int {
+	switch {
+	case p1 == p2:
+		return 0
+	case p1 == "":
+		return 1
+	case p2 == "":
+		return -1
+	}
+
+	ids1 := identifierSplitRe.Split(p1, -1)
+	ids2 := identifierSplitRe.Split(p2, -1)
+	for i := 0; i < len(ids1) && i < len(ids2); i++ {
+		if diff := compareIdentifiers(ids1[i], ids2[i]); diff != 0 {
+			return diff
+		}
+	}
+
+	//A larger set of identifiers has higher precedence, all else being equal
+	return signOf(int64(len(ids1) - len(ids2)))
+}
+
+//compareIdentifiers compares numeric identifiers numerically, which have
+// lower precedence than alphanumeric identifiers, which are compared in ASCII
+// order
+func compareIdentifiers(id1, id2 string) int {
+	num1 := digitsOnlyRegex.MatchString(id1)
+	num2 := digitsOnlyRegex.MatchString(id2)
+	switch {
+	case num1 && num2:
+		return compareNumericStrings(id1, id2)
+	case num1:
+		return -1
+	case num2:
+		return 1
+	}
+	return strings.Compare(id1, id2)
+}
+
+//compareNumericStrings compares strings of digits by numeric value, without
+// any risk of overflow
+func compareNumericStrings(n1, n2 string) int {
+	n1 = strings.TrimLeft(n1, "0")
+	n2 = strings.TrimLeft(n2, "0")
+	if len(n1) != len(n2) {
+		return signOf(int64(len(n1) - len(n2)))
+	}
+	return strings.Compare(n1, n2)
+}
+
+//BOSHDevVersionScheme orders the versions given to BOSH releases, where dev
+// releases built on top of a final version look like "270.2+dev.3", and come
+// after that final version but before the next one.
+type BOSHDevVersionScheme struct{}
+
+func (BOSHDevVersionScheme) Parses(version string) bool {
+	return boshDevRegex.MatchString(version)
+}
+
+func (BOSHDevVersionScheme) Compare(v1, v2 string) int {
+	m1 := boshDevRegex.FindStringSubmatch(v1)
+	m2 := boshDevRegex.FindStringSubmatch(v2)
+	if ret, done := compareUnparseable(v1, v2, m1 != nil, m2 != nil); done {
+		return ret
+	}
+
+	if diff := compareDottedNumbers(m1[1], m2[1]); diff != 0 {
+		return diff
+	}
+
+	switch {
+	case m1[2] == "" && m2[2] == "":
+		return 0
+	case m1[2] == "":
+		return -1
+	case m2[2] == "":
+		return 1
+	}
+	return compareNumericStrings(m1[2], m2[2])
+}
+
+//compareDottedNumbers compares versions such as "1.2.3", treating missing
+// components as zero
+func compareDottedNumbers(d1, d2 string) int {
+	return compareNumberLists(calverSplitRegex.Split(d1, -1), calverSplitRegex.Split(d2, -1))
+}
+
+func compareNumberLists(l1, l2 []string) int {
+	for i := 0; i < len(l1) || i < len(l2); i++ {
+		c1, c2 := "0", "0"
+		if i < len(l1) {
+			c1 = l1[i]
+		}
+		if i < len(l2) {
+			c2 = l2[i]
+		}
+		if diff := compareNumericStrings(c1, c2); diff != 0 {
+			return diff
+		}
+	}
+
+	return 0
+}
+
+//CalverVersionScheme orders calendar versions such as "2020.01.15" or "20.04",
+// comparing each numeric component by value. A trailing label, such as
+// "2020.01-beta", makes a version come before the same version without one.
+type CalverVersionScheme struct{}
+
+func (CalverVersionScheme) Parses(version string) bool {
+	return calverRegex.MatchString(version)
+}
+
+func (CalverVersionScheme) Compare(v1, v2 string) int {
+	m1 := calverRegex.FindStringSubmatch(v1)
+	m2 := calverRegex.FindStringSubmatch(v2)
+	if ret, done := compareUnparseable(v1, v2, m1 != nil, m2 != nil); done {
+		return ret
+	}
+
+	if diff := compareDottedNumbers(m1[1], m2[1]); diff != 0 {
+		return diff
+	}
+
+	return comparePrerelease(m1[2], m2[2])
+}
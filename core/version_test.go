@@ -0,0 +1,238 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/starkandwayne/signalfire/config"
+)
+
+//versionCorpus lists versions of real releases, in ascending order for the
+// given scheme
+var versionCorpus = []struct {
+	scheme   VersionScheme
+	name     string
+	versions []string
+}{
+	{
+		scheme: LegacyVersionScheme{},
+		name:   "legacy",
+		versions: []string{
+			"1.0.0-rc1",
+			"1.0.0-rc.2",
+			"1.0.0",
+			"1.0.1",
+			"1.2",
+			"9.3.0",
+			"10.0.0",
+			"270.2",
+			"270.10",
+			"1001.2.3",
+		},
+	},
+	{
+		scheme: SemverVersionScheme{},
+		name:   "semver",
+		versions: []string{
+			//Not semver, such as commit SHA builds, sort before everything else
+			"0f3c1a9",
+			"9b8e2d4c5a1f",
+			"1.0.0-alpha",
+			"1.0.0-alpha.1",
+			"1.0.0-alpha.beta",
+			"1.0.0-beta",
+			"1.0.0-beta.2",
+			"1.0.0-beta.11",
+			"1.0.0-rc.1",
+			"1.0.0",
+			"1.2.0-beta.3",
+			"1.2.0",
+			"1.2.0+build.5",
+			"1.10.0",
+			"v2.0.0",
+			"2.1.0-rc.1+sha.5114f85",
+			"2.1.0",
+			"10.0.0",
+		},
+	},
+	{
+		scheme: BOSHDevVersionScheme{},
+		name:   "bosh_dev",
+		versions: []string{
+			"a5c7d3e",
+			"0+dev.1",
+			"0+dev.12",
+			"1",
+			"1+dev.2",
+			"2",
+			"270.2",
+			"270.2+dev.3",
+			"270.2+dev.10",
+			"270.3",
+			"270.10",
+			"271",
+		},
+	},
+	{
+		scheme: CalverVersionScheme{},
+		name:   "calver",
+		versions: []string{
+			"nightly",
+			"2019.12.31",
+			"2020.01-beta",
+			"2020.01",
+			"2020.1.5",
+			"2020.02.01",
+			"2020.10.01",
+			"v2021.03.0",
+			"2021.03.1",
+		},
+	},
+}
+
+func TestVersionSchemeOrdersCorpus(t *testing.T) {
+	for _, test := range versionCorpus {
+		for i := range test.versions {
+			for j := range test.versions {
+				got := test.scheme.Compare(test.versions[i], test.versions[j])
+				want := signOf(int64(i - j))
+				if got != want {
+					t.Errorf("%s: Compare(%q, %q) = %d, want %d",
+						test.name, test.versions[i], test.versions[j], got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestVersionSchemeEquivalentVersions(t *testing.T) {
+	tests := []struct {
+		scheme VersionScheme
+		v1, v2 string
+	}{
+		{LegacyVersionScheme{}, "1.0", "1.0.0"},
+		{SemverVersionScheme{}, "v2.0.0", "2.0.0"},
+		{BOSHDevVersionScheme{}, "270.2", "270.2.0"},
+		{CalverVersionScheme{}, "2020.01.05", "2020.1.5"},
+	}
+
+	for _, test := range tests {
+		if got := test.scheme.Compare(test.v1, test.v2); got != 0 {
+			t.Errorf("%T: Compare(%q, %q) = %d, want 0", test.scheme, test.v1, test.v2, got)
+		}
+	}
+}
+
+func TestCollationReleaseSortsByScheme(t *testing.T) {
+	schemes, err := NewVersionSchemes(config.Versions{
+		Schemes: map[string]string{"cf-deployment": config.VersionSchemeSemver},
+	})
+	if err != nil {
+		t.Fatalf("NewVersionSchemes: %s", err)
+	}
+
+	want := []string{"1.2.0-beta.3", "1.2.0", "v2.0.0"}
+	inputs := []string{"v2.0.0", "1.2.0", "1.2.0-beta.3"}
+	group := newCollationDeploymentGroup(DefaultCollationDimension, "test")
+	for i, version := range inputs {
+		group.addDeployment(CollationDeployment{ID: string(rune('a' + i))},
+			CollationDeploymentInput{Releases: CacheReleases{{Name: "cf-deployment", Version: version}}},
+			schemes)
+	}
+
+	got := []string{}
+	for _, version := range group.Releases[0].Versions {
+		got = append(got, version.Version)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got versions %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got versions %v, want %v", got, want)
+		}
+	}
+}
+
+func TestNewVersionSchemesRejectsUnknownScheme(t *testing.T) {
+	_, err := NewVersionSchemes(config.Versions{DefaultScheme: "roman"})
+	if err == nil {
+		t.Errorf("expected an error for an unknown default scheme")
+	}
+
+	_, err = NewVersionSchemes(config.Versions{
+		Schemes: map[string]string{"uaa": "roman"},
+	})
+	if err == nil {
+		t.Errorf("expected an error for an unknown release scheme")
+	}
+}
+
+func TestVersionSchemeParses(t *testing.T) {
+	tests := []struct {
+		scheme  VersionScheme
+		version string
+		parses  bool
+	}{
+		{LegacyVersionScheme{}, "0f3c1a9", true},
+		{SemverVersionScheme{}, "0f3c1a9", false},
+		{SemverVersionScheme{}, "v2.0.0", true},
+		{BOSHDevVersionScheme{}, "a5c7d3e", false},
+		{BOSHDevVersionScheme{}, "270.2+dev.3", true},
+		{CalverVersionScheme{}, "nightly", false},
+		{CalverVersionScheme{}, "2020.01-beta", true},
+	}
+
+	for _, test := range tests {
+		if got := test.scheme.Parses(test.version); got != test.parses {
+			t.Errorf("%T: Parses(%q) = %t, want %t", test.scheme, test.version, got, test.parses)
+		}
+	}
+}
+
+func TestIncomparableVersionsAreLeftOutOfChecks(t *testing.T) {
+	schemes, err := NewVersionSchemes(config.Versions{
+		Schemes: map[string]string{"uaa": config.VersionSchemeSemver},
+	})
+	if err != nil {
+		t.Fatalf("NewVersionSchemes: %s", err)
+	}
+	policies, err := NewPolicies([]config.Policy{{Name: "uaa-minimum", Group: "*", Release: "uaa", MinVersion: "74.0.0"}})
+	if err != nil {
+		t.Fatalf("NewPolicies: %s", err)
+	}
+	rules, _ := NewCollationRules([]config.CollationRule{
+		{Type: config.CollationRuleTypeDeploymentRegex, Match: `^(\w+)-`},
+	})
+
+	c := newTestCollator(rules)
+	c.SetVersionSchemes(schemes)
+	c.AddPolicy(policies[0])
+	c.collateEnvironment(CacheEnvironment{
+		Name: "director",
+		UUID: "uuid",
+		Deployments: CacheDeployments{
+			{Name: "cf-sha", Releases: CacheReleases{{Name: "uaa", Version: "0f3c1a9"}}},
+			{Name: "cf-new", Releases: CacheReleases{{Name: "uaa", Version: "74.0.0"}}},
+			{Name: "cf-old", Releases: CacheReleases{{Name: "uaa", Version: "73.0.0"}}},
+		},
+	})
+
+	drift, err := c.GetDriftReport(DefaultCollationDimension, "cf")
+	if err != nil {
+		t.Fatalf("GetDriftReport: %s", err)
+	}
+	release := drift.Releases[0]
+	if release.Newest != "74.0.0" || len(release.Behind) != 1 || release.Behind[0].ID != "uuid/cf-old" {
+		t.Errorf("Expected only cf-old to be behind 74.0.0, got %+v", release)
+	}
+
+	violations := c.GetPolicyViolations()
+	if len(violations) != 1 || violations[0].DeploymentID != "uuid/cf-old" {
+		t.Errorf("Expected only cf-old to violate the minimum version, got %+v", violations)
+	}
+
+	advisory := Advisory{Release: "uaa", FixedVersion: "74.0.0"}
+	if advisory.Affects("uaa", "0f3c1a9", SemverVersionScheme{}) {
+		t.Errorf("Expected a commit SHA build not to be affected by an advisory")
+	}
+}
@@ -0,0 +1,577 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/starkandwayne/signalfire/bosh"
+	"github.com/starkandwayne/signalfire/bosh/boshtest"
+	"github.com/starkandwayne/signalfire/config"
+	"github.com/starkandwayne/signalfire/core"
+	"github.com/starkandwayne/signalfire/log"
+	"github.com/starkandwayne/signalfire/server"
+)
+
+const (
+	pollInterval = 50 * time.Millisecond
+	//eventuallyTimeout is how long to wait for changes on the directors to show
+	// up in the API
+	eventuallyTimeout = 5 * time.Second
+)
+
+//fleet is SignalFire watching some fake directors, with its API served over
+// HTTP
+type fleet struct {
+	scheduler *core.Scheduler
+	api       *httptest.Server
+	session   string
+}
+
+//fleetOptions changes how the fleet reaches its directors
+type fleetOptions struct {
+	//instancesPollInterval is zero if instances are not fetched
+	instancesPollInterval time.Duration
+	//requestTimeout is in seconds, and is the client default if zero
+	requestTimeout uint
+	//auth replaces the credentials of the fake directors' own client if set
+	auth *config.BOSHAuth
+}
+
+func startFleet(t *testing.T, directors ...*boshtest.Director) *fleet {
+	return startFleetWith(t, fleetOptions{}, directors...)
+}
+
+func startFleetWith(t *testing.T, opts fleetOptions, directors ...*boshtest.Director) *fleet {
+	logger := &log.Logger{Output: ioutil.Discard, Level: log.LevelFatal}
+	ret := &fleet{}
+
+	boshes := []core.BOSH{}
+	for _, director := range directors {
+		cfg := director.Config()
+		cfg.RequestTimeout = opts.requestTimeout
+		if opts.auth != nil {
+			cfg.Auth = *opts.auth
+		}
+		client, err := bosh.NewClient(cfg, logger)
+		if err != nil {
+			t.Fatalf("Could not create client for director %s: %s", director.UUID(), err)
+		}
+		boshes = append(boshes, core.BOSH{
+			Client:                client,
+			PollInterval:          pollInterval,
+			InstancesPollInterval: opts.instancesPollInterval,
+		})
+	}
+
+	rules, err := core.NewCollationRules(config.DefaultCollationRules)
+	if err != nil {
+		t.Fatalf("Could not parse collation rules: %s", err)
+	}
+	mode, err := core.ParseCollationMode(config.CollationModeFirstMatch)
+	if err != nil {
+		t.Fatalf("Could not parse collation mode: %s", err)
+	}
+
+	cache := core.NewCache()
+	collator := core.NewCollator(logger)
+	collator.SetMode(mode)
+	for _, rule := range rules {
+		collator.AddRule(rule)
+	}
+	collator.WatchAsync(cache)
+
+	ret.scheduler = core.NewScheduler(boshes, cache, logger)
+	ret.scheduler.Start()
+
+	serv, err := server.New(config.Server{Auth: config.Auth{Type: "none"}}, server.Components{
+		Collator: collator,
+		Cache:    cache,
+		Log:      logger,
+	})
+	if err != nil {
+		t.Fatalf("Could not create server: %s", err)
+	}
+	ret.api = httptest.NewServer(serv.Handler())
+
+	resp, err := http.Post(ret.api.URL+"/v1/auth", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("Could not authenticate: %s", err)
+	}
+	defer resp.Body.Close()
+	token := server.AuthTokenResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		t.Fatalf("Could not decode auth response: %s", err)
+	}
+	ret.session = token.Token
+
+	return ret
+}
+
+//close stops polling the directors, which also closes their clients, and stops
+// the API
+func (f *fleet) close() {
+	f.scheduler.Stop()
+	f.api.Close()
+}
+
+//get fetches the path from the API, decoding the response into out
+func (f *fleet) get(path string, out interface{}) error {
+	req, err := http.NewRequest("GET", f.api.URL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Signalfire-Session", f.session)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+//eventually calls check until it returns no error, failing the test if that
+// takes too long
+func eventually(t *testing.T, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(eventuallyTimeout)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out: %s", err)
+		}
+		time.Sleep(pollInterval / 2)
+	}
+}
+
+func (f *fleet) group(name string) (*server.APIGroupsGroup, error) {
+	groups := server.APIGroupsResponse{}
+	err := f.get("/v1/deployment-groups", &groups)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range groups.Groups {
+		if groups.Groups[i].Name == name {
+			return &groups.Groups[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("No group `%s'", name)
+}
+
+func (f *fleet) director(url string) (*server.APIDirectorsDirector, error) {
+	directors := server.APIDirectorsResponse{}
+	err := f.get("/v1/directors", &directors)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range directors.Directors {
+		if directors.Directors[i].URL == url {
+			return &directors.Directors[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("No director with URL `%s'", url)
+}
+
+//versions lists the versions of the release in the group, and the number of
+// deployments with each, such as "1.0.0:1,1.1.0:2"
+func versions(group *server.APIGroupsGroup, release string) string {
+	ret := []string{}
+	for _, r := range group.Releases {
+		if r.Name != release {
+			continue
+		}
+		for _, v := range r.Versions {
+			ret = append(ret, fmt.Sprintf("%s:%d", v.Version, len(v.Deployments)))
+		}
+	}
+
+	return strings.Join(ret, ",")
+}
+
+func cfDeployment(name, version string) boshtest.Deployment {
+	return boshtest.Deployment{
+		Name:      name,
+		Releases:  []bosh.Release{{Name: "cf", Version: version}},
+		Stemcells: []bosh.Stemcell{{Name: "bosh-warden-boshlite-ubuntu-xenial-go_agent", Version: "621.64"}},
+	}
+}
+
+func TestCollatesFleet(t *testing.T) {
+	dev := boshtest.NewDirector("dev-bosh", "dev-uuid")
+	defer dev.Close()
+	prod := boshtest.NewDirector("prod-bosh", "prod-uuid")
+	defer prod.Close()
+	dev.AddDeployment(cfDeployment("dev-cf", "1.0.0"))
+	prod.AddDeployment(cfDeployment("prod-cf", "1.1.0"))
+
+	f := startFleet(t, dev, prod)
+	defer f.close()
+
+	expectVersions := func(expected string, deployments int) func() error {
+		return func() error {
+			group, err := f.group("cf")
+			if err != nil {
+				return err
+			}
+			if len(group.Deployments) != deployments {
+				return fmt.Errorf("Expected %d deployments, got %d", deployments, len(group.Deployments))
+			}
+			if got := versions(group, "cf"); got != expected {
+				return fmt.Errorf("Expected cf versions %s, got %s", expected, got)
+			}
+			return nil
+		}
+	}
+
+	eventually(t, expectVersions("1.0.0:1,1.1.0:1", 2))
+
+	dev.SetReleaseVersion("dev-cf", "cf", "1.1.0")
+	eventually(t, expectVersions("1.1.0:2", 2))
+
+	prod.RemoveDeployment("prod-cf")
+	eventually(t, expectVersions("1.1.0:1", 1))
+}
+
+func TestKeepsDeploymentsWhenScrapeFails(t *testing.T) {
+	dev := boshtest.NewDirector("dev-bosh", "dev-uuid")
+	defer dev.Close()
+	dev.AddDeployment(cfDeployment("dev-cf", "1.0.0"))
+
+	f := startFleet(t, dev)
+	defer f.close()
+
+	eventually(t, func() error {
+		_, err := f.group("cf")
+		return err
+	})
+
+	dev.InjectFault("/deployments", boshtest.Fault{Status: http.StatusInternalServerError})
+	eventually(t, func() error {
+		director, err := f.director(dev.URL())
+		if err != nil {
+			return err
+		}
+		if director.Status != core.DirectorStatusUnreachable || director.ConsecutiveFailures < 2 {
+			return fmt.Errorf("Expected repeated failures, got %+v", director.APIScrapeStatus)
+		}
+		if director.LastScrapedAt == nil || director.LastError == "" {
+			return fmt.Errorf("Expected the last success and error to be known, got %+v", director.APIScrapeStatus)
+		}
+		return nil
+	})
+
+	group, err := f.group("cf")
+	if err != nil {
+		t.Fatalf("Expected the deployments of the failing director to be kept: %s", err)
+	}
+	scrape := group.Deployments[0].DirectorScrape
+	if scrape == nil || scrape.Status != core.DirectorStatusUnreachable {
+		t.Errorf("Expected the deployment to show that its director is unreachable, got %+v", scrape)
+	}
+
+	dev.ClearFaults()
+	eventually(t, func() error {
+		director, err := f.director(dev.URL())
+		if err != nil {
+			return err
+		}
+		if director.Status != core.DirectorStatusOK || director.ConsecutiveFailures != 0 {
+			return fmt.Errorf("Expected the director to recover, got %+v", director.APIScrapeStatus)
+		}
+		return nil
+	})
+}
+
+func TestLogsInAgainWhenTokensAreRevoked(t *testing.T) {
+	dev := boshtest.NewDirector("dev-bosh", "dev-uuid")
+	defer dev.Close()
+	dev.AddDeployment(cfDeployment("dev-cf", "1.0.0"))
+
+	f := startFleet(t, dev)
+	defer f.close()
+
+	eventually(t, func() error {
+		_, err := f.group("cf")
+		return err
+	})
+	logins := dev.Requests("/oauth/token")
+
+	dev.RevokeTokens()
+	dev.SetReleaseVersion("dev-cf", "cf", "1.1.0")
+	eventually(t, func() error {
+		group, err := f.group("cf")
+		if err != nil {
+			return err
+		}
+		if got := versions(group, "cf"); got != "1.1.0:1" {
+			return fmt.Errorf("Expected cf versions 1.1.0:1, got %s", got)
+		}
+		return nil
+	})
+
+	if dev.Requests("/oauth/token") <= logins {
+		t.Errorf("Expected the client to log in again")
+	}
+	director, err := f.director(dev.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if director.ConsecutiveFailures != 0 {
+		t.Errorf("Expected no failed scrapes, got %+v", director.APIScrapeStatus)
+	}
+}
+
+func TestLogsInAsUser(t *testing.T) {
+	dev := boshtest.NewDirector("dev-bosh", "dev-uuid")
+	defer dev.Close()
+	dev.AddDeployment(cfDeployment("dev-cf", "1.0.0"))
+
+	f := startFleetWith(t, fleetOptions{auth: &config.BOSHAuth{
+		ClientID: config.DefaultBOSHClientID,
+		Username: boshtest.Username,
+		Password: boshtest.Password,
+	}}, dev)
+	defer f.close()
+
+	eventually(t, func() error {
+		_, err := f.group("cf")
+		return err
+	})
+}
+
+func TestReportsRejectedCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		auth config.BOSHAuth
+		err  string
+	}{
+		{
+			name: "bad password",
+			auth: config.BOSHAuth{ClientID: config.DefaultBOSHClientID, Username: boshtest.Username, Password: "wrong"},
+			err:  "UAA rejected the username or password of user `admin': Could not authenticate: invalid_grant",
+		},
+		{
+			name: "bad client secret",
+			auth: config.BOSHAuth{ClientID: boshtest.ClientID, ClientSecret: "wrong"},
+			err:  "UAA rejected client `signalfire': Could not authenticate: unauthorized",
+		},
+	}
+
+	for _, test := range tests {
+		dev := boshtest.NewDirector("dev-bosh", "dev-uuid")
+		auth := test.auth
+		f := startFleetWith(t, fleetOptions{auth: &auth}, dev)
+
+		eventually(t, func() error {
+			director, err := f.director(dev.URL())
+			if err != nil {
+				return err
+			}
+			if director.Status != core.DirectorStatusUnreachable || !strings.Contains(director.LastError, test.err) {
+				return fmt.Errorf("%s: Expected the error `%s', got %+v", test.name, test.err, director)
+			}
+			return nil
+		})
+
+		f.close()
+		dev.Close()
+	}
+}
+
+func TestUnreachableDirector(t *testing.T) {
+	dev := boshtest.NewDirector("dev-bosh", "dev-uuid")
+	defer dev.Close()
+	dev.AddDeployment(cfDeployment("dev-cf", "1.0.0"))
+	down := boshtest.NewDirector("down-bosh", "down-uuid")
+	defer down.Close()
+	down.InjectFault("/info", boshtest.Fault{Status: http.StatusBadGateway})
+
+	f := startFleet(t, dev, down)
+	defer f.close()
+
+	eventually(t, func() error {
+		director, err := f.director(down.URL())
+		if err != nil {
+			return err
+		}
+		if director.Status != core.DirectorStatusUnreachable || director.UUID != "" || director.LastError == "" {
+			return fmt.Errorf("Expected the director to be unreachable, got %+v", director)
+		}
+		return nil
+	})
+
+	eventually(t, func() error {
+		_, err := f.group("cf")
+		return err
+	})
+}
+
+func TestDeploymentDetailAndConfigs(t *testing.T) {
+	dev := boshtest.NewDirector("dev-bosh", "dev-uuid")
+	defer dev.Close()
+	deployment := cfDeployment("dev-cf", "1.0.0")
+	deployment.Releases = append(deployment.Releases, bosh.Release{Name: "bosh-dns", Version: "1.17.0"})
+	//The add-on release is not in the manifest, as it comes from the runtime
+	// config
+	deployment.Manifest = "name: dev-cf\nreleases:\n- {name: cf, version: 1.0.0}\n"
+	dev.AddDeployment(deployment)
+	dev.AddTask("dev-cf", bosh.Task{State: "done", Description: "create deployment", User: "admin"})
+	dev.AddTask("dev-cf", bosh.Task{State: "error", Description: "create deployment", User: "ci", Result: "failed"})
+	dev.AddConfig(bosh.ConfigTypeRuntime, "dns", "releases:\n- {name: bosh-dns, version: 1.17.0}\n")
+	dev.AddConfig(bosh.ConfigTypeCloud, "default", "vm_types:\n- {name: small, cloud_properties: {cpu: 1}}\n")
+	dev.AddConfig(bosh.ConfigTypeCloud, "default", "vm_types:\n- {name: small, cloud_properties: {cpu: 2}}\n")
+
+	f := startFleet(t, dev)
+	defer f.close()
+
+	eventually(t, func() error {
+		detail := server.APIDeploymentResponse{}
+		err := f.get("/v1/deployments/dev-uuid/dev-cf", &detail)
+		if err != nil {
+			return err
+		}
+		if detail.LastDeploy == nil || detail.LastDeploy.User != "admin" || !detail.LastDeployFailed {
+			return fmt.Errorf("Unexpected task history %+v, %+v", detail.LastDeploy, detail.LastFailedTask)
+		}
+		for _, release := range detail.Releases {
+			expected := ""
+			if release.Name == "bosh-dns" {
+				expected = "dns"
+			}
+			if release.RuntimeConfig != expected {
+				return fmt.Errorf("Expected release %s to have runtime config `%s', got `%s'", release.Name, expected, release.RuntimeConfig)
+			}
+		}
+		return nil
+	})
+
+	configs := server.APIDirectorConfigsResponse{}
+	err := f.get("/v1/directors/dev-uuid/configs", &configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cloud *server.APIDirectorConfigsConfig
+	for i := range configs.Configs {
+		if configs.Configs[i].Type == bosh.ConfigTypeCloud {
+			cloud = &configs.Configs[i]
+		}
+	}
+	if cloud == nil || cloud.Previous == nil {
+		t.Fatalf("Expected two versions of the cloud config, got %+v", configs)
+	}
+	if len(cloud.Differences) != 1 || cloud.Differences[0].Path != "/vm_types/small/cloud_properties/cpu" {
+		t.Errorf("Unexpected cloud config differences %+v", cloud.Differences)
+	}
+}
+
+func TestFetchesInstances(t *testing.T) {
+	dev := boshtest.NewDirector("dev-bosh", "dev-uuid")
+	defer dev.Close()
+	index := 0
+	deployment := cfDeployment("dev-cf", "1.0.0")
+	deployment.Instances = []bosh.Instance{
+		{InstanceGroup: "router", Index: &index, ID: "router-id", ProcessState: "running", VMCID: "vm-1", IPs: []string{"10.0.0.1"}},
+		{InstanceGroup: "api", Index: &index, ID: "api-id", ProcessState: "failing", VMCID: "vm-2"},
+		//Errands have no VM between runs, so do not count towards health
+		{InstanceGroup: "smoke-tests", Index: &index, ID: "smoke-tests-id"},
+	}
+	dev.AddDeployment(deployment)
+
+	f := startFleetWith(t, fleetOptions{instancesPollInterval: pollInterval}, dev)
+	defer f.close()
+
+	expectHealth := func(healthy, unhealthy int) func() error {
+		return func() error {
+			instances := server.APIDeploymentInstancesResponse{}
+			err := f.get("/v1/deployments/dev-uuid/dev-cf/instances", &instances)
+			if err != nil {
+				return err
+			}
+			if !instances.Fetched || len(instances.Instances) != 3 {
+				return fmt.Errorf("Expected three fetched instances, got %+v", instances)
+			}
+			if instances.Healthy != healthy || instances.Unhealthy != unhealthy {
+				return fmt.Errorf("Expected %d healthy and %d unhealthy instances, got %+v", healthy, unhealthy, instances)
+			}
+			return nil
+		}
+	}
+
+	eventually(t, expectHealth(1, 1))
+
+	deployment.Instances[1].ProcessState = "running"
+	dev.SetInstances("dev-cf", deployment.Instances)
+	eventually(t, expectHealth(2, 0))
+}
+
+func TestTimesOutSlowDirectors(t *testing.T) {
+	dev := boshtest.NewDirector("dev-bosh", "dev-uuid")
+	defer dev.Close()
+	dev.AddDeployment(cfDeployment("dev-cf", "1.0.0"))
+	//This director accepts connections, but never answers
+	hung := boshtest.NewDirector("hung-bosh", "hung-uuid")
+	defer hung.Close()
+	hung.InjectFault("/info", boshtest.Fault{Delay: time.Minute})
+
+	f := startFleetWith(t, fleetOptions{requestTimeout: 1}, dev, hung)
+	defer f.close()
+
+	eventually(t, func() error {
+		director, err := f.director(hung.URL())
+		if err != nil {
+			return err
+		}
+		if director.Status != core.DirectorStatusUnreachable || director.LastError == "" {
+			return fmt.Errorf("Expected the director to be unreachable, got %+v", director)
+		}
+		return nil
+	})
+
+	eventually(t, func() error {
+		_, err := f.group("cf")
+		return err
+	})
+
+	dev.InjectFault("/deployments", boshtest.Fault{Delay: time.Minute})
+	eventually(t, func() error {
+		director, err := f.director(dev.URL())
+		if err != nil {
+			return err
+		}
+		if director.Status != core.DirectorStatusUnreachable || director.ConsecutiveFailures < 2 {
+			return fmt.Errorf("Expected scrapes to time out repeatedly, got %+v", director.APIScrapeStatus)
+		}
+		return nil
+	})
+
+	dev.ClearFaults()
+	eventually(t, func() error {
+		director, err := f.director(dev.URL())
+		if err != nil {
+			return err
+		}
+		if director.Status != core.DirectorStatusOK {
+			return fmt.Errorf("Expected the director to recover, got %+v", director.APIScrapeStatus)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/starkandwayne/signalfire/core"
+)
+
+type APIAdvisories struct {
+	collator *core.Collator
+	feed     *core.AdvisoryFeed
+}
+
+func NewAPIAdvisories(collator *core.Collator, feed *core.AdvisoryFeed) *APIAdvisories {
+	return &APIAdvisories{collator: collator, feed: feed}
+}
+
+type APIAdvisoriesResponse struct {
+	LoadedAt   *time.Time         `json:"loaded_at"`
+	LastError  string             `json:"last_error,omitempty"`
+	Advisories []APIAdvisoryMatch `json:"advisories"`
+}
+
+type APIAdvisoryMatch struct {
+	ID           string                  `json:"id"`
+	Release      string                  `json:"release"`
+	Severity     string                  `json:"severity"`
+	FixedVersion string                  `json:"fixed_version,omitempty"`
+	Deployments  []APIAdvisoryDeployment `json:"deployments"`
+}
+
+type APIAdvisoryDeployment struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+}
+
+func (a *APIAdvisories) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.feed == nil {
+		writeResponse(w, http.StatusNotFound, APIError{Error: "No advisory feed is configured"})
+		return
+	}
+
+	responseObj := APIAdvisoriesResponse{Advisories: []APIAdvisoryMatch{}}
+	loadedAt, err := a.feed.Status()
+	if !loadedAt.IsZero() {
+		responseObj.LoadedAt = &loadedAt
+	}
+	if err != nil {
+		responseObj.LastError = err.Error()
+	}
+
+	for _, match := range a.collator.GetAdvisoryMatches() {
+		toAdd := APIAdvisoryMatch{
+			ID:           match.Advisory.ID,
+			Release:      match.Advisory.Release,
+			Severity:     match.Advisory.Severity,
+			FixedVersion: match.Advisory.FixedVersion,
+			Deployments:  []APIAdvisoryDeployment{},
+		}
+		for _, dep := range match.Deployments {
+			toAdd.Deployments = append(toAdd.Deployments, APIAdvisoryDeployment{
+				ID:      dep.ID,
+				Version: dep.Version,
+			})
+		}
+		responseObj.Advisories = append(responseObj.Advisories, toAdd)
+	}
+	sort.SliceStable(responseObj.Advisories, func(i, j int) bool {
+		return responseObj.Advisories[i].ID < responseObj.Advisories[j].ID
+	})
+
+	writeResponse(w, http.StatusOK, &responseObj)
+}
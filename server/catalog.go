@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/starkandwayne/signalfire/core"
+)
+
+type APICatalog struct {
+	catalog *core.Catalog
+}
+
+func NewAPICatalog(catalog *core.Catalog) *APICatalog {
+	return &APICatalog{catalog: catalog}
+}
+
+type APICatalogResponse struct {
+	Source string `json:"source"`
+	//RefreshedAt is null if the catalog has never been read successfully
+	RefreshedAt *time.Time          `json:"refreshed_at"`
+	LastError   string              `json:"last_error,omitempty"`
+	Releases    []APICatalogRelease `json:"releases"`
+}
+
+type APICatalogRelease struct {
+	Name   string `json:"name"`
+	Latest string `json:"latest"`
+}
+
+func (a *APICatalog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.catalog == nil {
+		writeResponse(w, http.StatusNotFound, APIError{Error: "No release catalog is configured"})
+		return
+	}
+
+	state := a.catalog.GetState()
+	responseObj := APICatalogResponse{
+		Source:   state.Source,
+		Releases: []APICatalogRelease{},
+	}
+	if !state.RefreshedAt.IsZero() {
+		responseObj.RefreshedAt = &state.RefreshedAt
+	}
+	if state.LastError != nil {
+		responseObj.LastError = state.LastError.Error()
+	}
+	for name, latest := range state.Latest {
+		responseObj.Releases = append(responseObj.Releases, APICatalogRelease{
+			Name:   name,
+			Latest: latest,
+		})
+	}
+	sort.Slice(responseObj.Releases, func(i, j int) bool {
+		return responseObj.Releases[i].Name < responseObj.Releases[j].Name
+	})
+
+	writeResponse(w, http.StatusOK, &responseObj)
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/starkandwayne/signalfire/config"
+	"github.com/starkandwayne/signalfire/core"
+)
+
+type APIDryRun struct {
+	cache    *core.Cache
+	collator *core.Collator
+}
+
+func NewAPIDryRun(cache *core.Cache, collator *core.Collator) *APIDryRun {
+	return &APIDryRun{cache: cache, collator: collator}
+}
+
+type APIDryRunRequest struct {
+	Mode  string                 `json:"mode"`
+	Rules []config.CollationRule `json:"rules"`
+}
+
+type APIDryRunResponse struct {
+	Groups    []APIGroupsGroup                 `json:"groups"`
+	Ungrouped map[string][]APIGroupsDeployment `json:"ungrouped"`
+	Traces    []APIDryRunTrace                 `json:"traces"`
+}
+
+type APIDryRunTrace struct {
+	Deployment string                `json:"deployment"`
+	Matches    []APIDryRunTraceHit   `json:"matches"`
+	Errors     []APIDryRunTraceError `json:"errors,omitempty"`
+}
+
+type APIDryRunTraceHit struct {
+	Rule      int      `json:"rule"`
+	Dimension string   `json:"dimension"`
+	Group     string   `json:"group"`
+	Captures  []string `json:"captures,omitempty"`
+	Applied   bool     `json:"applied"`
+}
+
+type APIDryRunTraceError struct {
+	Rule  int    `json:"rule"`
+	Error string `json:"error"`
+}
+
+func (a *APIDryRun) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestParameters := APIDryRunRequest{Mode: config.CollationModeFirstMatch}
+	jsonDec := json.NewDecoder(r.Body)
+	err := jsonDec.Decode(&requestParameters)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, APIError{Error: "JSON request body could not be parsed"})
+		return
+	}
+
+	mode, err := core.ParseCollationMode(requestParameters.Mode)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, APIError{Error: err.Error()})
+		return
+	}
+
+	if len(requestParameters.Rules) == 0 {
+		writeResponse(w, http.StatusBadRequest, APIError{Error: "At least one rule is required"})
+		return
+	}
+
+	rules, err := core.NewCollationRules(requestParameters.Rules)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, APIError{Error: fmt.Sprintf("Invalid rules: %s", err)})
+		return
+	}
+
+	result := core.DryRunCollation(a.cache.GetEnvironments(), rules, mode, a.collator.GetVersionSchemes())
+	responseObj := APIDryRunResponse{
+		Groups:    []APIGroupsGroup{},
+		Ungrouped: map[string][]APIGroupsDeployment{},
+		Traces:    []APIDryRunTrace{},
+	}
+	scrapes := a.cache.GetScrapeStatuses()
+	for _, group := range result.Groups {
+		responseObj.Groups = append(responseObj.Groups, encodeGroup(group, scrapes))
+	}
+	sort.Slice(responseObj.Groups, func(i, j int) bool {
+		if responseObj.Groups[i].Dimension != responseObj.Groups[j].Dimension {
+			return responseObj.Groups[i].Dimension < responseObj.Groups[j].Dimension
+		}
+		return responseObj.Groups[i].Name < responseObj.Groups[j].Name
+	})
+
+	for dimension, deployments := range result.Ungrouped {
+		responseObj.Ungrouped[dimension] = encodeDeployments(deployments, scrapes)
+	}
+
+	for _, trace := range result.Traces {
+		toAdd := APIDryRunTrace{
+			Deployment: trace.DeploymentID,
+			Matches:    []APIDryRunTraceHit{},
+		}
+		for _, match := range trace.Matches {
+			toAdd.Matches = append(toAdd.Matches, APIDryRunTraceHit{
+				Rule:      match.RuleIdx,
+				Dimension: match.Dimension,
+				Group:     match.Group,
+				Captures:  match.Captures,
+				Applied:   match.Applied,
+			})
+		}
+		for _, ruleErr := range trace.Errors {
+			toAdd.Errors = append(toAdd.Errors, APIDryRunTraceError{
+				Rule:  ruleErr.RuleIdx,
+				Error: ruleErr.Error,
+			})
+		}
+		responseObj.Traces = append(responseObj.Traces, toAdd)
+	}
+	sort.Slice(responseObj.Traces, func(i, j int) bool {
+		return responseObj.Traces[i].Deployment < responseObj.Traces[j].Deployment
+	})
+
+	writeResponse(w, http.StatusOK, &responseObj)
+}
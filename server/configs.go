@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/starkandwayne/signalfire/core"
+)
+
+type APIDirectorConfigs struct {
+	cache *core.Cache
+}
+
+func NewAPIDirectorConfigs(cache *core.Cache) *APIDirectorConfigs {
+	return &APIDirectorConfigs{cache: cache}
+}
+
+type APIDirectorConfigsResponse struct {
+	Director string                     `json:"director_id"`
+	Configs  []APIDirectorConfigsConfig `json:"configs"`
+}
+
+type APIDirectorConfigsConfig struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	ID   string `json:"id"`
+	//CreatedAt is null if the director did not give a valid timestamp
+	CreatedAt *time.Time `json:"created_at"`
+	//Previous is null if the config has only one known version
+	Previous *APIDirectorConfigsVersion `json:"previous"`
+	//Differences are from the previous version, and empty if there is none
+	Differences []APIYAMLDifference `json:"differences"`
+	//Error explains why the versions could not be compared, if they could not
+	Error string `json:"error,omitempty"`
+}
+
+type APIDirectorConfigsVersion struct {
+	ID        string     `json:"id"`
+	CreatedAt *time.Time `json:"created_at"`
+}
+
+func (a *APIDirectorConfigs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+	env, found := a.cache.GetEnvironment(uuid)
+	if !found {
+		writeResponse(w, http.StatusNotFound, APIError{Error: fmt.Sprintf("No director with UUID `%s'", uuid)})
+		return
+	}
+
+	configs := env.Director.Configs
+	responseObj := APIDirectorConfigsResponse{
+		Director: uuid,
+		Configs:  []APIDirectorConfigsConfig{},
+	}
+	for _, config := range configs.Latest() {
+		toAdd := APIDirectorConfigsConfig{
+			Type:        config.Type,
+			Name:        config.Name,
+			ID:          config.ID,
+			CreatedAt:   optionalTime(config.CreatedAt),
+			Differences: []APIYAMLDifference{},
+		}
+
+		previous, found := configs.Previous(config)
+		if found {
+			toAdd.Previous = &APIDirectorConfigsVersion{
+				ID:        previous.ID,
+				CreatedAt: optionalTime(previous.CreatedAt),
+			}
+		}
+
+		switch {
+		case !found:
+		case config.Unparsable || previous.Unparsable:
+			toAdd.Error = "Could not compare versions, as one could not be parsed"
+		default:
+			differences, err := core.DiffYAML(previous.Content, config.Content)
+			if err != nil {
+				writeResponse(w, http.StatusInternalServerError, APIError{Error: err.Error()})
+				return
+			}
+			toAdd.Differences = encodeYAMLDifferences(differences)
+		}
+
+		responseObj.Configs = append(responseObj.Configs, toAdd)
+	}
+
+	writeResponse(w, http.StatusOK, &responseObj)
+}
+
+//optionalTime returns nil for the zero time, so that it is encoded as null
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
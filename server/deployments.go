@@ -0,0 +1,212 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/starkandwayne/signalfire/core"
+)
+
+type APIUngroupedDeployments struct {
+	collator *core.Collator
+	cache    *core.Cache
+}
+
+func NewAPIUngroupedDeployments(collator *core.Collator, cache *core.Cache) *APIUngroupedDeployments {
+	return &APIUngroupedDeployments{collator: collator, cache: cache}
+}
+
+type APIUngroupedDeploymentsResponse struct {
+	Deployments []APIGroupsDeployment `json:"deployments"`
+}
+
+func (a *APIUngroupedDeployments) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	responseObj := APIUngroupedDeploymentsResponse{
+		Deployments: encodeDeployments(
+			a.collator.GetUngroupedDeploymentsByDimension(requestedDimension(r)),
+			a.cache.GetScrapeStatuses(),
+		),
+	}
+
+	writeResponse(w, http.StatusOK, &responseObj)
+}
+
+type APIDeploymentInstances struct {
+	cache *core.Cache
+}
+
+func NewAPIDeploymentInstances(cache *core.Cache) *APIDeploymentInstances {
+	return &APIDeploymentInstances{cache: cache}
+}
+
+type APIDeploymentInstancesResponse struct {
+	Deployment string `json:"deployment"`
+	//Fetched is false if the instances of the deployment have not been fetched
+	// from the director yet
+	Fetched   bool          `json:"fetched"`
+	Healthy   int           `json:"healthy"`
+	Unhealthy int           `json:"unhealthy"`
+	Instances []APIInstance `json:"instances"`
+}
+
+type APIInstance struct {
+	InstanceGroup string   `json:"instance_group"`
+	Index         *int     `json:"index"`
+	ID            string   `json:"id"`
+	AZ            string   `json:"az"`
+	ProcessState  string   `json:"process_state"`
+	VMType        string   `json:"vm_type"`
+	VMCID         string   `json:"vm_cid"`
+	IPs           []string `json:"ips"`
+}
+
+//lookupDeployment finds the deployment named by the `uuid' and `name' route
+// variables, which together make up a deployment ID
+func lookupDeployment(cache *core.Cache, r *http.Request) (*core.CacheDeployment, bool) {
+	vars := mux.Vars(r)
+	env, found := cache.GetEnvironment(vars["uuid"])
+	if !found {
+		return nil, false
+	}
+
+	for i := range env.Deployments {
+		if env.Deployments[i].Name == vars["name"] {
+			return &env.Deployments[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func (a *APIDeploymentInstances) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	deployment, found := lookupDeployment(a.cache, r)
+	if !found {
+		writeResponse(w, http.StatusNotFound, APIError{Error: "No such deployment"})
+		return
+	}
+
+	responseObj := APIDeploymentInstancesResponse{
+		Deployment: mux.Vars(r)["uuid"] + "/" + deployment.Name,
+		Fetched:    deployment.Instances != nil,
+		Instances:  []APIInstance{},
+	}
+	responseObj.Healthy, responseObj.Unhealthy = deployment.Instances.Health()
+	for _, instance := range deployment.Instances {
+		ips := instance.IPs
+		if ips == nil {
+			ips = []string{}
+		}
+		responseObj.Instances = append(responseObj.Instances, APIInstance{
+			InstanceGroup: instance.InstanceGroup,
+			Index:         instance.Index,
+			ID:            instance.ID,
+			AZ:            instance.AZ,
+			ProcessState:  instance.ProcessState,
+			VMType:        instance.VMType,
+			VMCID:         instance.VMCID,
+			IPs:           ips,
+		})
+	}
+
+	writeResponse(w, http.StatusOK, &responseObj)
+}
+
+type APIDeployment struct {
+	cache *core.Cache
+}
+
+func NewAPIDeployment(cache *core.Cache) *APIDeployment {
+	return &APIDeployment{cache: cache}
+}
+
+type APIDeploymentResponse struct {
+	ID        string                  `json:"id"`
+	Name      string                  `json:"name"`
+	Director  string                  `json:"director_id"`
+	Releases  []APIDeploymentRelease  `json:"releases"`
+	Stemcells []APIDeploymentStemcell `json:"stemcells"`
+	Tags      map[string]string       `json:"tags"`
+	Healthy   int                     `json:"healthy_instances"`
+	Unhealthy int                     `json:"unhealthy_instances"`
+	//LastDeploy is null if no successful deploy is among the recent tasks
+	LastDeploy *APITask `json:"last_deploy"`
+	//LastFailedTask is null if no failed task is among the recent tasks
+	LastFailedTask   *APITask `json:"last_failed_task"`
+	LastDeployFailed bool     `json:"last_deploy_failed"`
+}
+
+type APIDeploymentRelease struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	//RuntimeConfig names the runtime config which adds the release as an
+	// add-on, and is omitted if the release is in the deployment manifest
+	RuntimeConfig string `json:"runtime_config,omitempty"`
+}
+
+type APIDeploymentStemcell struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type APITask struct {
+	ID          int       `json:"id"`
+	State       string    `json:"state"`
+	Description string    `json:"description"`
+	User        string    `json:"user"`
+	Result      string    `json:"result"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+func (a *APIDeployment) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	deployment, found := lookupDeployment(a.cache, r)
+	if !found {
+		writeResponse(w, http.StatusNotFound, APIError{Error: "No such deployment"})
+		return
+	}
+
+	uuid := mux.Vars(r)["uuid"]
+	responseObj := APIDeploymentResponse{
+		ID:        uuid + "/" + deployment.Name,
+		Name:      deployment.Name,
+		Director:  uuid,
+		Releases:  []APIDeploymentRelease{},
+		Stemcells: []APIDeploymentStemcell{},
+		Tags:      deployment.Tags,
+
+		LastDeploy:       encodeTask(deployment.Tasks.LastDeploy),
+		LastFailedTask:   encodeTask(deployment.Tasks.LastFailed),
+		LastDeployFailed: deployment.Tasks.LastDeployFailed,
+	}
+	if responseObj.Tags == nil {
+		responseObj.Tags = map[string]string{}
+	}
+	responseObj.Healthy, responseObj.Unhealthy = deployment.Instances.Health()
+	for _, release := range deployment.Releases {
+		responseObj.Releases = append(responseObj.Releases, APIDeploymentRelease{
+			Name:          release.Name,
+			Version:       release.Version,
+			RuntimeConfig: release.RuntimeConfig,
+		})
+	}
+	for _, stemcell := range deployment.Stemcells {
+		responseObj.Stemcells = append(responseObj.Stemcells, APIDeploymentStemcell{Name: stemcell.Name, Version: stemcell.Version})
+	}
+
+	writeResponse(w, http.StatusOK, &responseObj)
+}
+
+func encodeTask(task *core.CacheTask) *APITask {
+	if task == nil {
+		return nil
+	}
+
+	return &APITask{
+		ID:          task.ID,
+		State:       task.State,
+		Description: task.Description,
+		User:        task.User,
+		Result:      task.Result,
+		FinishedAt:  task.FinishedAt,
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/starkandwayne/signalfire/core"
+)
+
+func TestAPIDeploymentInstances(t *testing.T) {
+	index := 0
+	cache := core.NewCache()
+	cache.UpdateEnvironment(core.CacheEnvironment{
+		UUID:        "uuid",
+		Deployments: core.CacheDeployments{{Name: "cf"}, {Name: "redis"}},
+	})
+	cache.UpdateInstances("uuid", "cf", core.CacheInstances{
+		{InstanceGroup: "router", Index: &index, ID: "a", VMCID: "vm-1", ProcessState: "running", IPs: []string{"10.0.16.4"}},
+		{InstanceGroup: "diego-cell", Index: &index, ID: "b", VMCID: "vm-2", ProcessState: "failing"},
+		{InstanceGroup: "smoke-tests", Index: &index, ID: "c"},
+	})
+
+	router := mux.NewRouter()
+	router.Handle("/v1/deployments/{uuid}/{name}/instances", NewAPIDeploymentInstances(cache))
+
+	tests := []struct {
+		path      string
+		status    int
+		fetched   bool
+		healthy   int
+		unhealthy int
+		instances int
+	}{
+		{"/v1/deployments/uuid/cf/instances", http.StatusOK, true, 1, 1, 3},
+		{"/v1/deployments/uuid/redis/instances", http.StatusOK, false, 0, 0, 0},
+		{"/v1/deployments/uuid/concourse/instances", http.StatusNotFound, false, 0, 0, 0},
+		{"/v1/deployments/other/cf/instances", http.StatusNotFound, false, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", test.path, nil))
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.status, recorder.Code)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		response := APIDeploymentInstancesResponse{}
+		err := json.Unmarshal(recorder.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatalf("%s: Could not parse response: %s", test.path, err)
+		}
+		if response.Fetched != test.fetched || response.Healthy != test.healthy ||
+			response.Unhealthy != test.unhealthy || len(response.Instances) != test.instances {
+			t.Errorf("%s: Unexpected response %+v", test.path, response)
+		}
+		for _, instance := range response.Instances {
+			if instance.IPs == nil {
+				t.Errorf("%s: Expected IPs of instance %s to be a list", test.path, instance.ID)
+			}
+		}
+	}
+}
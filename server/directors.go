@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"sort"
+	"time"
 
 	"github.com/starkandwayne/signalfire/core"
 )
@@ -21,8 +22,34 @@ type APIDirectorsResponse struct {
 }
 
 type APIDirectorsDirector struct {
-	Name string `json:"name"`
-	UUID string `json:"uuid"`
+	Name     string          `json:"name"`
+	UUID     string          `json:"uuid"`
+	URL      string          `json:"url"`
+	Version  string          `json:"version"`
+	CPI      string          `json:"cpi"`
+	Features map[string]bool `json:"features"`
+	APIScrapeStatus
+}
+
+//APIScrapeStatus describes how fresh the data from a director is
+type APIScrapeStatus struct {
+	Status string `json:"status"`
+	//LastScrapedAt is null if the director has never been scraped successfully
+	LastScrapedAt *time.Time `json:"last_scraped_at"`
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+	//LastError is omitted if the last attempt succeeded
+	LastError           string `json:"last_error,omitempty"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+}
+
+func encodeScrapeStatus(scrape core.CacheScrapeStatus) APIScrapeStatus {
+	return APIScrapeStatus{
+		Status:              scrape.Status,
+		LastScrapedAt:       optionalTime(scrape.LastSuccessAt),
+		LastAttemptAt:       optionalTime(scrape.LastAttemptAt),
+		LastError:           scrape.LastError,
+		ConsecutiveFailures: scrape.ConsecutiveFailures,
+	}
 }
 
 func (a *APIDirectors) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -34,14 +61,25 @@ func (a *APIDirectors) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		responseObj.Directors = append(
 			responseObj.Directors,
 			APIDirectorsDirector{
-				Name: env.Name,
-				UUID: env.UUID,
+				Name:     env.Name,
+				UUID:     env.UUID,
+				URL:      env.URL,
+				Version:  env.Director.Version,
+				CPI:      env.Director.CPI,
+				Features: env.Director.Features,
+
+				APIScrapeStatus: encodeScrapeStatus(env.Scrape),
 			},
 		)
 	}
 	sort.Slice(responseObj.Directors,
 		func(i, j int) bool {
-			return responseObj.Directors[i].UUID < responseObj.Directors[j].UUID
+			a, b := responseObj.Directors[i], responseObj.Directors[j]
+			if a.UUID != b.UUID {
+				return a.UUID < b.UUID
+			}
+			//Directors which have never been reached have no UUID
+			return a.URL < b.URL
 		},
 	)
 
@@ -54,3 +92,39 @@ func (a *APIDirectors) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	writeResponseBytes(w, code, out)
 }
+
+type APIDirectorVersions struct {
+	cache *core.Cache
+}
+
+func NewAPIDirectorVersions(cache *core.Cache) *APIDirectorVersions {
+	return &APIDirectorVersions{cache: cache}
+}
+
+type APIDirectorVersionsResponse struct {
+	Newest   string                       `json:"newest"`
+	Versions []APIDirectorVersionsVersion `json:"versions"`
+}
+
+type APIDirectorVersionsVersion struct {
+	Version        string   `json:"version"`
+	VersionsBehind int      `json:"versions_behind"`
+	Directors      []string `json:"directors"`
+}
+
+func (a *APIDirectorVersions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := core.NewDirectorVersionReport(a.cache.GetEnvironments())
+	responseObj := APIDirectorVersionsResponse{
+		Newest:   report.Newest,
+		Versions: []APIDirectorVersionsVersion{},
+	}
+	for _, version := range report.Versions {
+		responseObj.Versions = append(responseObj.Versions, APIDirectorVersionsVersion{
+			Version:        version.Version,
+			VersionsBehind: version.VersionsBehind,
+			Directors:      version.Directors,
+		})
+	}
+
+	writeResponse(w, http.StatusOK, &responseObj)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/starkandwayne/signalfire/core"
+)
+
+type APIDrift struct {
+	collator *core.Collator
+}
+
+func NewAPIDrift(collator *core.Collator) *APIDrift {
+	return &APIDrift{collator: collator}
+}
+
+type APIDriftResponse struct {
+	Group     string            `json:"group"`
+	Dimension string            `json:"dimension"`
+	Score     int               `json:"drift_score"`
+	Releases  []APIDriftRelease `json:"releases"`
+}
+
+type APIDriftRelease struct {
+	Name   string               `json:"name"`
+	Newest string               `json:"newest"`
+	Behind []APIDriftDeployment `json:"behind"`
+}
+
+type APIDriftDeployment struct {
+	ID             string `json:"id"`
+	Version        string `json:"version"`
+	VersionsBehind int    `json:"versions_behind"`
+}
+
+func (a *APIDrift) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report, err := a.collator.GetDriftReport(requestedDimension(r), mux.Vars(r)["name"])
+	if err != nil {
+		writeResponse(w, http.StatusNotFound, APIError{Error: err.Error()})
+		return
+	}
+
+	responseObj := APIDriftResponse{
+		Group:     report.Group,
+		Dimension: report.Dimension,
+		Score:     report.Score,
+		Releases:  []APIDriftRelease{},
+	}
+	for _, release := range report.Releases {
+		toAdd := APIDriftRelease{
+			Name:   release.Name,
+			Newest: release.Newest,
+			Behind: []APIDriftDeployment{},
+		}
+		for _, dep := range release.Behind {
+			toAdd.Behind = append(toAdd.Behind, APIDriftDeployment{
+				ID:             dep.ID,
+				Version:        dep.Version,
+				VersionsBehind: dep.VersionsBehind,
+			})
+		}
+		responseObj.Releases = append(responseObj.Releases, toAdd)
+	}
+	sort.Slice(responseObj.Releases, func(i, j int) bool {
+		return responseObj.Releases[i].Name < responseObj.Releases[j].Name
+	})
+
+	writeResponse(w, http.StatusOK, &responseObj)
+}
@@ -2,34 +2,58 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"sort"
+	"time"
 
 	"github.com/starkandwayne/signalfire/core"
 )
 
 type APIGroups struct {
 	collator *core.Collator
+	cache    *core.Cache
 }
 
-func NewAPIGroups(collator *core.Collator) *APIGroups {
-	return &APIGroups{collator: collator}
+func NewAPIGroups(collator *core.Collator, cache *core.Cache) *APIGroups {
+	return &APIGroups{collator: collator, cache: cache}
 }
 
 type APIGroupsResponse struct {
-	Groups []APIGroupsGroup `json:"groups"`
+	//Dimension is the tag dimension that the groups were sorted along
+	Dimension string `json:"dimension"`
+	//Dimensions lists every dimension which can be requested
+	Dimensions []string         `json:"dimensions"`
+	Groups     []APIGroupsGroup `json:"groups"`
+	//UngroupedCount is the number of deployments which matched no rule, and so
+	// do not appear in any group
+	UngroupedCount int `json:"ungrouped_count"`
 }
 
 type APIGroupsGroup struct {
-	Name        string                `json:"name"`
-	Deployments []APIGroupsDeployment `json:"deployments"`
-	Releases    []APIGroupsRelease    `json:"releases"`
+	Name             string                `json:"name"`
+	Dimension        string                `json:"dimension"`
+	Deployments      []APIGroupsDeployment `json:"deployments"`
+	Releases         []APIGroupsRelease    `json:"releases"`
+	Stemcells        []APIGroupsStemcell   `json:"stemcells"`
+	DriftScore       int                   `json:"drift_score"`
+	PolicyViolations int                   `json:"policy_violations"`
+	Healthy          int                   `json:"healthy_instances"`
+	Unhealthy        int                   `json:"unhealthy_instances"`
 }
 
 type APIGroupsDeployment struct {
-	Name         string `json:"name"`
-	ID           string `json:"id"`
-	DirectorUUID string `json:"director_id"`
+	Name         string              `json:"name"`
+	ID           string              `json:"id"`
+	DirectorUUID string              `json:"director_id"`
+	Tags         map[string][]string `json:"tags"`
+	Healthy      int                 `json:"healthy_instances"`
+	Unhealthy    int                 `json:"unhealthy_instances"`
+	//LastDeployedAt is null if no recent successful deploy is known
+	LastDeployedAt   *time.Time `json:"last_deployed_at"`
+	LastDeployFailed bool       `json:"last_deploy_failed"`
+	//DirectorScrape is null if the director of the deployment is not known
+	DirectorScrape *APIScrapeStatus `json:"director_scrape"`
 }
 
 type APIGroupsRelease struct {
@@ -37,31 +61,54 @@ type APIGroupsRelease struct {
 	Versions []APIGroupsVersion `json:"versions"`
 }
 
+type APIGroupsStemcell struct {
+	OS       string             `json:"os"`
+	Versions []APIGroupsVersion `json:"versions"`
+}
+
 type APIGroupsVersion struct {
 	Version     string   `json:"version"`
 	Deployments []string `json:"deployments"`
+	//CatalogStatus is one of current, outdated, or unknown, and is empty if no
+	// catalog is configured
+	CatalogStatus string              `json:"catalog_status,omitempty"`
+	Advisories    []APIGroupsAdvisory `json:"advisories"`
+}
+
+type APIGroupsAdvisory struct {
+	ID           string `json:"id"`
+	Severity     string `json:"severity"`
+	FixedVersion string `json:"fixed_version,omitempty"`
 }
 
 func (a *APIGroups) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	groups := a.collator.GetDeploymentGroups()
+	dimension := requestedDimension(r)
+	groups, found := a.collator.GetDeploymentGroupsByDimension(dimension)
+	if !found {
+		writeResponse(w, http.StatusNotFound, APIError{Error: fmt.Sprintf("Unknown dimension `%s'", dimension)})
+		return
+	}
+
 	responseObj := APIGroupsResponse{
-		Groups: []APIGroupsGroup{},
+		Dimension:      dimension,
+		Dimensions:     a.collator.GetDimensions(),
+		Groups:         []APIGroupsGroup{},
+		UngroupedCount: len(a.collator.GetUngroupedDeploymentsByDimension(dimension)),
 	}
+	scrapes := a.cache.GetScrapeStatuses()
 	for _, group := range groups {
-		responseObj.Groups = append(
-			responseObj.Groups,
-			APIGroupsGroup{
-				Name:        group.Name,
-				Deployments: a.encodeDeployments(group.Deployments),
-				Releases:    a.encodeReleases(group.Releases),
-			},
-		)
+		responseObj.Groups = append(responseObj.Groups, encodeGroup(group, scrapes))
 	}
-	sort.Slice(responseObj.Groups,
-		func(i, j int) bool {
-			return responseObj.Groups[i].Name < responseObj.Groups[j].Name
-		},
-	)
+	sortGroups := sortGroupsByName
+	switch r.URL.Query().Get("sort") {
+	case "", "name":
+	case "drift":
+		sortGroups = sortGroupsByDrift
+	default:
+		writeResponse(w, http.StatusBadRequest, APIError{Error: "The `sort' parameter must be one of `name' or `drift'"})
+		return
+	}
+	sortGroups(responseObj.Groups)
 
 	code := http.StatusOK
 	out, err := json.Marshal(&responseObj)
@@ -73,38 +120,114 @@ func (a *APIGroups) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	writeResponseBytes(w, code, out)
 }
 
-func (a *APIGroups) encodeDeployments(deployments []core.CollationDeployment) []APIGroupsDeployment {
+//requestedDimension returns the tag dimension named by the `dimension' query
+// parameter, or the default dimension if none was given
+func requestedDimension(r *http.Request) string {
+	dimension := r.URL.Query().Get("dimension")
+	if dimension == "" {
+		dimension = core.DefaultCollationDimension
+	}
+
+	return dimension
+}
+
+func sortGroupsByName(groups []APIGroupsGroup) {
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+}
+
+//sortGroupsByDrift puts the groups which are most out of sync first
+func sortGroupsByDrift(groups []APIGroupsGroup) {
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].DriftScore != groups[j].DriftScore {
+			return groups[i].DriftScore > groups[j].DriftScore
+		}
+		return groups[i].Name < groups[j].Name
+	})
+}
+
+func encodeGroup(group core.CollationDeploymentGroup, scrapes map[string]core.CacheScrapeStatus) APIGroupsGroup {
+	healthy, unhealthy := group.InstanceHealth()
+	return APIGroupsGroup{
+		Name:             group.Name,
+		Dimension:        group.Dimension,
+		Deployments:      encodeDeployments(group.Deployments, scrapes),
+		Releases:         encodeReleases(group.Releases),
+		Stemcells:        encodeStemcells(group.Stemcells),
+		DriftScore:       group.Drift().Score,
+		PolicyViolations: group.PolicyViolations,
+		Healthy:          healthy,
+		Unhealthy:        unhealthy,
+	}
+}
+
+//encodeDeployments describes the deployments, along with how fresh the data
+// from their directors is, given the scrape statuses of directors by UUID
+func encodeDeployments(deployments []core.CollationDeployment, scrapes map[string]core.CacheScrapeStatus) []APIGroupsDeployment {
 	ret := make([]APIGroupsDeployment, 0, len(deployments))
 	for _, dep := range deployments {
+		var directorScrape *APIScrapeStatus
+		if scrape, found := scrapes[dep.DirectorUUID]; found {
+			encoded := encodeScrapeStatus(scrape)
+			directorScrape = &encoded
+		}
 		ret = append(ret, APIGroupsDeployment{
 			ID:           dep.ID,
 			Name:         dep.Name,
 			DirectorUUID: dep.DirectorUUID,
+			Tags:         dep.Tags,
+			Healthy:      dep.HealthyInstances,
+			Unhealthy:    dep.UnhealthyInstances,
+
+			LastDeployedAt:   optionalTime(dep.LastDeployedAt),
+			LastDeployFailed: dep.LastDeployFailed,
+			DirectorScrape:   directorScrape,
 		})
 	}
 	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
 	return ret
 }
 
-func (a *APIGroups) encodeReleases(releases []core.CollationRelease) []APIGroupsRelease {
+func encodeReleases(releases []core.CollationRelease) []APIGroupsRelease {
 	ret := make([]APIGroupsRelease, 0, len(releases))
 	for _, release := range releases {
 		ret = append(ret, APIGroupsRelease{
 			Name:     release.Name,
-			Versions: a.encodeVersions(release.Versions),
+			Versions: encodeVersions(release.Versions),
 		})
 	}
 	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
 	return ret
 }
 
-func (a *APIGroups) encodeVersions(versions []core.CollationReleaseVersion) []APIGroupsVersion {
+func encodeStemcells(stemcells []core.CollationStemcell) []APIGroupsStemcell {
+	ret := make([]APIGroupsStemcell, 0, len(stemcells))
+	for _, stemcell := range stemcells {
+		ret = append(ret, APIGroupsStemcell{
+			OS:       stemcell.OS,
+			Versions: encodeVersions(stemcell.Versions),
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].OS < ret[j].OS })
+	return ret
+}
+
+func encodeVersions(versions []core.CollationReleaseVersion) []APIGroupsVersion {
 	ret := make([]APIGroupsVersion, 0, len(versions))
 	for _, version := range versions {
-		ret = append(ret, APIGroupsVersion{
-			Version:     version.Version,
-			Deployments: version.Deployments,
-		})
+		toAdd := APIGroupsVersion{
+			Version:       version.Version,
+			Deployments:   version.Deployments,
+			CatalogStatus: version.CatalogStatus,
+			Advisories:    []APIGroupsAdvisory{},
+		}
+		for _, advisory := range version.Advisories {
+			toAdd.Advisories = append(toAdd.Advisories, APIGroupsAdvisory{
+				ID:           advisory.ID,
+				Severity:     advisory.Severity,
+				FixedVersion: advisory.FixedVersion,
+			})
+		}
+		ret = append(ret, toAdd)
 	}
 	return ret
 }
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/starkandwayne/signalfire/core"
+)
+
+type APIManifestDiff struct {
+	collator *core.Collator
+	cache    *core.Cache
+}
+
+func NewAPIManifestDiff(collator *core.Collator, cache *core.Cache) *APIManifestDiff {
+	return &APIManifestDiff{collator: collator, cache: cache}
+}
+
+type APIManifestDiffResponse struct {
+	A           string              `json:"a"`
+	B           string              `json:"b"`
+	Differences []APIYAMLDifference `json:"differences"`
+}
+
+type APIYAMLDifference struct {
+	Path string      `json:"path"`
+	Kind string      `json:"kind"`
+	A    interface{} `json:"a,omitempty"`
+	B    interface{} `json:"b,omitempty"`
+}
+
+func (a *APIManifestDiff) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	idA, idB := r.URL.Query().Get("a"), r.URL.Query().Get("b")
+	if idA == "" || idB == "" {
+		writeResponse(w, http.StatusBadRequest, APIError{Error: "The `a' and `b' parameters are required"})
+		return
+	}
+
+	dimension, groupName := requestedDimension(r), mux.Vars(r)["name"]
+	group, found := a.collator.GetDeploymentGroup(dimension, groupName)
+	if !found {
+		writeResponse(w, http.StatusNotFound, APIError{Error: fmt.Sprintf("No group with name `%s' in dimension `%s'", groupName, dimension)})
+		return
+	}
+
+	manifests := []string{}
+	for _, id := range []string{idA, idB} {
+		if !groupHasDeployment(group, id) {
+			writeResponse(w, http.StatusNotFound, APIError{Error: fmt.Sprintf("No deployment with ID `%s' in group `%s'", id, groupName)})
+			return
+		}
+
+		manifest, found := a.manifest(id)
+		if !found {
+			writeResponse(w, http.StatusNotFound, APIError{Error: fmt.Sprintf("The manifest of deployment `%s' has not been fetched", id)})
+			return
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	//The deployment name always differs, so it is not worth reporting
+	differences, err := core.DiffYAML(manifests[0], manifests[1], "name")
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, APIError{Error: err.Error()})
+		return
+	}
+
+	responseObj := APIManifestDiffResponse{
+		A:           idA,
+		B:           idB,
+		Differences: encodeYAMLDifferences(differences),
+	}
+
+	writeResponse(w, http.StatusOK, &responseObj)
+}
+
+//manifest returns the redacted manifest of the deployment with the given ID
+func (a *APIManifestDiff) manifest(id string) (string, bool) {
+	parts := strings.SplitN(id, "/", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+
+	env, found := a.cache.GetEnvironment(parts[0])
+	if !found {
+		return "", false
+	}
+
+	for _, dep := range env.Deployments {
+		if dep.Name == parts[1] {
+			return dep.Manifest, dep.Manifest != ""
+		}
+	}
+
+	return "", false
+}
+
+func groupHasDeployment(group core.CollationDeploymentGroup, id string) bool {
+	for _, dep := range group.Deployments {
+		if dep.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func encodeYAMLDifferences(differences []core.YAMLDifference) []APIYAMLDifference {
+	ret := make([]APIYAMLDifference, 0, len(differences))
+	for _, difference := range differences {
+		ret = append(ret, APIYAMLDifference{
+			Path: difference.Path,
+			Kind: difference.Kind,
+			A:    difference.A,
+			B:    difference.B,
+		})
+	}
+
+	return ret
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/starkandwayne/signalfire/core"
+)
+
+type APIPipeline struct {
+	collator *core.Collator
+}
+
+func NewAPIPipeline(collator *core.Collator) *APIPipeline {
+	return &APIPipeline{collator: collator}
+}
+
+type APIPipelineResponse struct {
+	Group     string               `json:"group"`
+	Dimension string               `json:"dimension"`
+	Stages    []APIPipelineStage   `json:"stages"`
+	Releases  []APIPipelineRelease `json:"releases"`
+	Unstaged  []string             `json:"unstaged"`
+}
+
+type APIPipelineStage struct {
+	Name        string   `json:"name"`
+	Deployments []string `json:"deployments"`
+}
+
+type APIPipelineRelease struct {
+	Name   string                    `json:"name"`
+	Stages []APIPipelineReleaseStage `json:"stages"`
+}
+
+type APIPipelineReleaseStage struct {
+	Stage   string `json:"stage"`
+	Version string `json:"version,omitempty"`
+	Status  string `json:"status"`
+}
+
+func (a *APIPipeline) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report, err := a.collator.GetPipelineReport(requestedDimension(r), mux.Vars(r)["name"])
+	if err != nil {
+		writeResponse(w, http.StatusNotFound, APIError{Error: err.Error()})
+		return
+	}
+
+	responseObj := APIPipelineResponse{
+		Group:     report.Group,
+		Dimension: report.Dimension,
+		Stages:    []APIPipelineStage{},
+		Releases:  []APIPipelineRelease{},
+		Unstaged:  report.Unstaged,
+	}
+	for _, stage := range report.Stages {
+		responseObj.Stages = append(responseObj.Stages, APIPipelineStage{
+			Name:        stage.Name,
+			Deployments: stage.Deployments,
+		})
+	}
+	for _, release := range report.Releases {
+		toAdd := APIPipelineRelease{Name: release.Name}
+		for _, stage := range release.Stages {
+			toAdd.Stages = append(toAdd.Stages, APIPipelineReleaseStage{
+				Stage:   stage.Stage,
+				Version: stage.Version,
+				Status:  stage.Status,
+			})
+		}
+		responseObj.Releases = append(responseObj.Releases, toAdd)
+	}
+	sort.Slice(responseObj.Releases, func(i, j int) bool {
+		return responseObj.Releases[i].Name < responseObj.Releases[j].Name
+	})
+
+	writeResponse(w, http.StatusOK, &responseObj)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/starkandwayne/signalfire/core"
+)
+
+type APIPolicyViolations struct {
+	collator *core.Collator
+}
+
+func NewAPIPolicyViolations(collator *core.Collator) *APIPolicyViolations {
+	return &APIPolicyViolations{collator: collator}
+}
+
+type APIPolicyViolationsResponse struct {
+	Violations []APIPolicyViolation `json:"violations"`
+}
+
+type APIPolicyViolation struct {
+	Policy       string `json:"policy"`
+	Dimension    string `json:"dimension"`
+	Group        string `json:"group"`
+	DeploymentID string `json:"deployment"`
+	Release      string `json:"release"`
+	Version      string `json:"version"`
+	Required     string `json:"required"`
+}
+
+func (a *APIPolicyViolations) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	responseObj := APIPolicyViolationsResponse{Violations: []APIPolicyViolation{}}
+	for _, violation := range a.collator.GetPolicyViolations() {
+		responseObj.Violations = append(responseObj.Violations, APIPolicyViolation{
+			Policy:       violation.Policy,
+			Dimension:    violation.Dimension,
+			Group:        violation.Group,
+			DeploymentID: violation.DeploymentID,
+			Release:      violation.Release,
+			Version:      violation.Version,
+			Required:     violation.Required,
+		})
+	}
+
+	writeResponse(w, http.StatusOK, &responseObj)
+}
@@ -24,7 +24,11 @@ type Server struct {
 type Components struct {
 	Collator *core.Collator
 	Cache    *core.Cache
-	Log      *log.Logger
+	//Catalog is nil if no release catalog is configured
+	Catalog *core.Catalog
+	//Advisories is nil if no advisory feed is configured
+	Advisories *core.AdvisoryFeed
+	Log        *log.Logger
 }
 
 func New(conf config.Server, components Components) (*Server, error) {
@@ -84,8 +88,21 @@ func (s *Server) newRouter(auth Authorizer, t *tokenChecker, components Componen
 
 	ret.Handle("/v1/info", NewAPIInfo(version.Version, auth.TypeName())).Methods("GET")
 	ret.Handle("/v1/auth", auth).Methods("POST")
-	ret.Handle("/v1/deployment-groups", t.wrap(NewAPIGroups(components.Collator))).Methods("GET")
+	ret.Handle("/v1/deployment-groups", t.wrap(NewAPIGroups(components.Collator, components.Cache))).Methods("GET")
+	ret.Handle("/v1/deployment-groups/{name}/pipeline", t.wrap(NewAPIPipeline(components.Collator))).Methods("GET")
+	ret.Handle("/v1/deployment-groups/{name}/drift", t.wrap(NewAPIDrift(components.Collator))).Methods("GET")
+	ret.Handle("/v1/deployment-groups/{name}/manifest-diff", t.wrap(NewAPIManifestDiff(components.Collator, components.Cache))).Methods("GET")
+	ret.Handle("/v1/deployments/ungrouped", t.wrap(NewAPIUngroupedDeployments(components.Collator, components.Cache))).Methods("GET")
+	ret.Handle("/v1/deployments/{uuid}/{name}", t.wrap(NewAPIDeployment(components.Cache))).Methods("GET")
+	ret.Handle("/v1/deployments/{uuid}/{name}/instances", t.wrap(NewAPIDeploymentInstances(components.Cache))).Methods("GET")
+	ret.Handle("/v1/stemcells", t.wrap(NewAPIStemcells(components.Collator))).Methods("GET")
+	ret.Handle("/v1/collation/dry-run", t.wrap(NewAPIDryRun(components.Cache, components.Collator))).Methods("POST")
+	ret.Handle("/v1/advisories", t.wrap(NewAPIAdvisories(components.Collator, components.Advisories))).Methods("GET")
+	ret.Handle("/v1/policy/violations", t.wrap(NewAPIPolicyViolations(components.Collator))).Methods("GET")
+	ret.Handle("/v1/catalog", t.wrap(NewAPICatalog(components.Catalog))).Methods("GET")
 	ret.Handle("/v1/directors", t.wrap(NewAPIDirectors(components.Cache))).Methods("GET")
+	ret.Handle("/v1/directors/{uuid}/configs", t.wrap(NewAPIDirectorConfigs(components.Cache))).Methods("GET")
+	ret.Handle("/v1/directors/versions", t.wrap(NewAPIDirectorVersions(components.Cache))).Methods("GET")
 
 	return ret
 }
@@ -115,6 +132,12 @@ func (s *Server) newTLSConfig(conf config.Server) (*tls.Config, error) {
 	}, nil
 }
 
+//Handler returns the handler which serves the API, so that it can be served
+// some other way than by Run, such as from a test
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler
+}
+
 func (s *Server) Run() error {
 	if s.server.TLSConfig == nil {
 		s.logger.Info("Starting up HTTP server (non-TLS)")
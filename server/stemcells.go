@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/starkandwayne/signalfire/core"
+)
+
+type APIStemcells struct {
+	collator *core.Collator
+}
+
+func NewAPIStemcells(collator *core.Collator) *APIStemcells {
+	return &APIStemcells{collator: collator}
+}
+
+type APIStemcellsResponse struct {
+	Stemcells []APIGroupsStemcell `json:"stemcells"`
+}
+
+func (a *APIStemcells) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	responseObj := APIStemcellsResponse{
+		Stemcells: encodeStemcells(a.collator.GetStemcells()),
+	}
+
+	writeResponse(w, http.StatusOK, &responseObj)
+}